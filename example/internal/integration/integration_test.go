@@ -14,7 +14,7 @@ func TestIntegration(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	if err := mgr.Run(ctx, "./testdata", testmanager.NewTestReporter(t)); err != nil {
+	if _, err := mgr.Run(ctx, "./testdata", testmanager.NewTestReporter(t)); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -2,6 +2,7 @@ package integration
 
 import (
 	"context"
+	_ "embed"
 	"fmt"
 	"io"
 	"log"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
 	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nais/tester/example/internal/database"
@@ -27,19 +29,27 @@ import (
 	"github.com/testcontainers/testcontainers-go/wait"
 )
 
+//go:embed openapi.yaml
+var openAPI []byte
+
 func TestRunner(skipPostgres bool) (*testmanager.Manager, error) {
 	ctx := context.Background()
 
 	var setup testmanager.SetupFunc = func(ctx context.Context, dir string, config any) (retCtx context.Context, runners []spec.Runner, close func(), err error) {
 		return ctx, nil, nil, fmt.Errorf("no setup function provided")
 	}
+	coverage := runner.NewGQLCoverage()
+	restCoverage := runner.NewRestCoverage()
 	if !skipPostgres {
-		setup = newManager(ctx)
+		setup = newManager(ctx, coverage, restCoverage)
 	}
-	mgr, err := testmanager.New(newConfig, setup, &runner.GQL{}, &runner.SQL{}, &runner.REST{})
+	mgr, err := testmanager.New(newConfig, setup, newGQLRunner(ctx, nil, nil), &runner.SQL{}, &runner.REST{})
 	if err != nil {
 		return nil, err
 	}
+	mgr.SetGQLCoverage(coverage)
+	mgr.SetRestCoverage(restCoverage)
+	mgr.SetCookieJar(true)
 
 	// if err := mgr.Run(ctx, os.DirFS("./testdata")); err != nil {
 	// 	return nil, err
@@ -48,7 +58,7 @@ func TestRunner(skipPostgres bool) (*testmanager.Manager, error) {
 	return mgr, nil
 }
 
-func newManager(ctx context.Context) testmanager.SetupFunc {
+func newManager(ctx context.Context, coverage *runner.GQLCoverage, restCoverage *runner.RestCoverage) testmanager.SetupFunc {
 	container, connStr, err := startPostgresql(ctx)
 	if err != nil {
 		panic(err)
@@ -74,8 +84,8 @@ func newManager(ctx context.Context) testmanager.SetupFunc {
 		}
 
 		runners := []spec.Runner{
-			newRestRunner(),
-			newGQLRunner(ctx, db),
+			newRestRunner(restCoverage),
+			newGQLRunner(ctx, db, coverage),
 			runner.NewSQLRunner(pool),
 		}
 
@@ -88,18 +98,19 @@ func newManager(ctx context.Context) testmanager.SetupFunc {
 	}
 }
 
-func newRestRunner() spec.Runner {
+func newRestRunner(coverage *runner.RestCoverage) spec.Runner {
 	router := http.NewServeMux()
 
 	router.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintln(w, `{"message": "hello world"}`)
 	})
 
-	return runner.NewRestRunner(router)
+	return runner.NewRestRunner(router, runner.RestOpenAPI(openAPI), runner.RestRecordCoverage(coverage))
 }
 
-func newGQLRunner(_ context.Context, db *database.Queries) spec.Runner {
+func newGQLRunner(_ context.Context, db *database.Queries, coverage *runner.GQLCoverage) spec.Runner {
 	log := logrus.New()
 	log.Out = io.Discard
 
@@ -107,16 +118,22 @@ func newGQLRunner(_ context.Context, db *database.Queries) spec.Runner {
 
 	newServer := func(es graphql.ExecutableSchema) *handler.Server {
 		srv := handler.New(es)
+		srv.AddTransport(transport.Websocket{})
 		srv.AddTransport(transport.SSE{})
 		srv.AddTransport(transport.GET{})
 		srv.AddTransport(transport.POST{})
+		srv.Use(extension.Introspection{})
 
 		return srv
 	}
 
 	srv := newServer(graph.NewExecutableSchema(graph.Config{Resolvers: resolver}))
 
-	return runner.NewGQLRunner(srv)
+	opts := []runner.GQLOption{runner.GQLIntrospectSchema()}
+	if coverage != nil {
+		opts = append(opts, runner.GQLRecordCoverage(coverage))
+	}
+	return runner.NewGQLRunner(srv, opts...)
 }
 
 func startPostgresql(ctx context.Context) (*postgres.PostgresContainer, string, error) {
@@ -27,6 +27,159 @@ function NotNull()
 	return {}
 end
 
+--- Ensure the field is a string matching the regular expression. Uses Go regexp syntax
+---@param regex string
+---@return userdata
+function Matches(regex)
+  print("Matches: ", regex)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a number between min and max, inclusive
+---@param min number
+---@param max number
+---@return userdata
+function Between(min, max)
+  print("Between: ", min, max)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a number greater than n
+---@param n number
+---@return userdata
+function GreaterThan(n)
+  print("GreaterThan: ", n)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a number less than n
+---@param n number
+---@return userdata
+function LessThan(n)
+  print("LessThan: ", n)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is of the given type. A table is either a list or a map
+---@param type "string"|"number"|"boolean"|"table"|"list"|"map"|"null"
+---@return userdata
+function IsType(type)
+  print("IsType: ", type)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is equal to one of the values
+---@param ... string|number|boolean
+---@return userdata
+function OneOf(...)
+  print("OneOf: ", ...)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is an RFC3339 timestamp, or a date on the form YYYY-MM-DD
+---@return userdata
+function ISODate()
+  print("ISODate")
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a list containing exactly the given elements, in any order
+---@param elements any[]
+---@return userdata
+function Unordered(elements)
+  print("Unordered: ", elements)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a list containing the given elements, in any order. Other elements are allowed
+---@param elements any[]
+---@return userdata
+function ContainsElements(elements)
+  print("ContainsElements: ", elements)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a map with the given fields. Other fields are ignored
+---@param fields table<string, any>
+---@return userdata
+function Partial(fields)
+  print("Partial: ", fields)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a list, map or string of the given length
+---@param n integer
+---@return userdata
+function Length(n)
+  print("Length: ", n)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure fn returns true when called with the field
+---@param fn fun(v: any): boolean
+---@return userdata
+function Satisfies(fn)
+  print("Satisfies: ", fn)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Compare the value against the snapshot with the given name. The snapshot is written
+--- with the actual value if it doesn't exist, or if snapshots are being updated.
+--- Fields in overrides replace the snapshot, e.g. to use Ignore() or NotNull() for dynamic fields.
+--- An override list with a single element is applied to every element in the list.
+---@param name string Name of the snapshot, unique within the file
+---@param overrides? table
+---@return userdata
+function MatchSnapshot(name, overrides)
+  print("MatchSnapshot: ", name, overrides)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Call fn until it no longer raises an error, or the timeout expires.
+--- Every failed attempt is logged.
+---@param fn fun()
+---@param opts? {timeout?: string|number, interval?: string|number} Defaults to a timeout of 5s and an interval of 100ms
+function Eventually(fn, opts)
+  print("Eventually: ", fn, opts)
+end
+
+--- Run fn once before the next test in the file. If it fails, the remaining tests are skipped
+---@param fn fun()
+function BeforeAll(fn)
+  print("BeforeAll: ", fn)
+end
+
+--- Run fn before each test declared after this call. It receives the same argument as the test function
+---@param fn fun(t: any)
+function BeforeEach(fn)
+  print("BeforeEach: ", fn)
+end
+
+--- Run fn after each test declared after this call, even if the test failed. It receives the same argument as the test function
+---@param fn fun(t: any)
+function AfterEach(fn)
+  print("AfterEach: ", fn)
+end
+
+--- Run fn once after all tests in the file are done
+---@param fn fun()
+function AfterAll(fn)
+  print("AfterAll: ", fn)
+end
+
 --- Save the field to the state. By default it will error if the field is null
 ---@param name string Name of the field in the state
 ---@param allowNull? boolean
@@ -46,22 +199,67 @@ State = {}
 ---@diagnostic disable-next-line: assign-type-mismatch
 Null = {}
 
+--- Options for a single test
+---@class TestOptions
+---@field timeout? string|number Maximum duration of the test, e.g. "5s". Numbers are seconds
+---@field retry? integer Number of times to run a failing test again
+
 ---@class TestFunctionTgql
 local TestFunctionTgql = {}
 
---- Query comment
+--- Run the query, and return the decoded response
 ---@param query string
----@param headers? table
-function TestFunctionTgql.query(query, headers)
+---@param opts? {variables?: table<string, any>, operationName?: string, headers?: table<string, string>, files?: table<string, string|{content: string, filename?: string, contentType?: string}>}
+---@return table
+function TestFunctionTgql.query(query, opts)
   print("query")
+  return {}
 end
 
 --- Check comment
----@param resp table
+---@param resp gql.Response|userdata
 function TestFunctionTgql.check(resp)
   print("check")
 end
 
+--- Check the errors of the response from query
+---@param expected (string|{message?: any, path?: any, extensions?: table<string, any>})[]
+function TestFunctionTgql.checkErrors(expected)
+  print("checkErrors")
+end
+
+--- Fail if the response from query has errors
+function TestFunctionTgql.expectNoErrors()
+  print("expectNoErrors")
+end
+
+--- Start a subscription, collecting events in the background. A previous subscription in the test is closed
+---@param query string
+---@param opts? {variables?: table<string, any>, operationName?: string, headers?: table<string, string>, transport?: "sse" | "ws"}
+function TestFunctionTgql.subscribe(query, opts)
+  print("subscribe")
+end
+
+--- Wait for the next event of the subscription, and return it. The event is also used by check
+---@param timeout? string|number
+---@return table
+function TestFunctionTgql.nextEvent(timeout)
+  print("nextEvent")
+  return {}
+end
+
+--- Check all events received by the subscription. The check is retried as events arrive until it passes, or the subscription completes or times out
+---@param expected table|userdata
+---@param timeout? string|number
+function TestFunctionTgql.checkEvents(expected, timeout)
+  print("checkEvents")
+end
+
+--- Close the subscription
+function TestFunctionTgql.unsubscribe()
+  print("unsubscribe")
+end
+
 --- Add a header to the request
 ---@param key string
 ---@param value string
@@ -69,25 +267,57 @@ function TestFunctionTgql.addHeader(key, value)
   print("addHeader")
 end
 
+--- Return all cookies in the cookie jar
+---@return {name: string, value: string, domain: string, path: string, expires?: string, secure: boolean, httpOnly: boolean}[]
+function TestFunctionTgql.cookies()
+  print("cookies")
+  return {}
+end
+
+--- Return the value of the cookie, or nil if it's not in the cookie jar
+---@param name string
+---@return string
+function TestFunctionTgql.cookie(name)
+  print("cookie")
+  return ""
+end
+
+--- Add a cookie to the cookie jar
+---@param name string
+---@param value string
+---@param opts? {domain?: string, path?: string, secure?: boolean, httpOnly?: boolean}
+function TestFunctionTgql.setCookie(name, value, opts)
+  print("setCookie")
+end
+
+--- Remove all cookies from the cookie jar
+function TestFunctionTgql.clearCookies()
+  print("clearCookies")
+end
+
 ---@class TestFunctionTsql
 local TestFunctionTsql = {}
 
---- Query for multiple rows
+--- Query for multiple rows, and return the rows
 ---@param query string
 ---@param ... string|boolean|number
+---@return table[]
 function TestFunctionTsql.query(query, ...)
   print("query")
+  return {}
 end
 
---- Query for a single row. Will error if no rows returned
+--- Query for a single row, and return the row. Will error if no rows returned
 ---@param query string
 ---@param ... string|boolean|number
+---@return table
 function TestFunctionTsql.queryRow(query, ...)
   print("queryRow")
+  return {}
 end
 
 --- Check comment
----@param resp table
+---@param resp table|userdata
 function TestFunctionTsql.check(resp)
   print("check")
 end
@@ -95,12 +325,15 @@ end
 ---@class TestFunctionTrest
 local TestFunctionTrest = {}
 
---- Send http request
+--- Send http request, and return the response. The body is decoded if it's JSON
 ---@param method "GET" | "POST" | "PUT" | "DELETE" | "PATCH" | "OPTIONS" | "HEAD"
 ---@param path string
 ---@param body? string|table
-function TestFunctionTrest.send(method, path, body)
+---@param opts? {query?: table<string, string|number|boolean|(string|number|boolean)[]>, form?: table<string, string|number|boolean|(string|number|boolean)[]>, multipart?: table<string, string|number|{file?: string, content?: string, filename?: string, contentType?: string}>, base64?: string, contentType?: string, headers?: table<string, string>, openapi?: boolean}
+---@return {status: number, headers: table<string, string>, body: any}
+function TestFunctionTrest.send(method, path, body, opts)
   print("send")
+  return {}
 end
 
 --- Add a header to the request
@@ -110,18 +343,95 @@ function TestFunctionTrest.addHeader(key, value)
   print("addHeader")
 end
 
---- Check the response done by send
+--- Check the response done by send. The body is decoded based on the content type: JSON and XML are decoded to tables, other bodies are strings, and empty bodies are nil
 ---@param status_code number
----@param resp table
+---@param resp table|userdata
 function TestFunctionTrest.check(status_code, resp)
   print("check")
 end
 
+--- Check the headers of the response done by send
+---@param headers table<string, string|userdata>
+function TestFunctionTrest.checkHeaders(headers)
+  print("checkHeaders")
+end
+
+--- Check the raw body of the response done by send
+---@param body string|userdata
+function TestFunctionTrest.checkBody(body)
+  print("checkBody")
+end
+
+--- Return all cookies in the cookie jar
+---@return {name: string, value: string, domain: string, path: string, expires?: string, secure: boolean, httpOnly: boolean}[]
+function TestFunctionTrest.cookies()
+  print("cookies")
+  return {}
+end
+
+--- Return the value of the cookie, or nil if it's not in the cookie jar
+---@param name string
+---@return string
+function TestFunctionTrest.cookie(name)
+  print("cookie")
+  return ""
+end
+
+--- Add a cookie to the cookie jar
+---@param name string
+---@param value string
+---@param opts? {domain?: string, path?: string, secure?: boolean, httpOnly?: boolean}
+function TestFunctionTrest.setCookie(name, value, opts)
+  print("setCookie")
+end
+
+--- Remove all cookies from the cookie jar
+function TestFunctionTrest.clearCookies()
+  print("clearCookies")
+end
+
+--- GraphQL types
+
+---@class gql.Mutation
+---@field createUser? gql.User|userdata
+
+---@class gql.NewUser
+---@field name? string|userdata
+
+---@class gql.Query
+---@field users? gql.User[]|userdata
+---@field user? gql.User|userdata
+
+---@class gql.User
+---@field id? string|userdata
+---@field name? string|userdata
+---@field email? string|userdata
+
+--- Data of a GraphQL response, with the fields of all root types
+---@class gql.Data
+---@field users? gql.User[]|userdata
+---@field user? gql.User|userdata
+---@field createUser? gql.User|userdata
+
+--- GraphQL response
+---@class gql.Response
+---@field data? gql.Data|userdata
+---@field errors? table|userdata
+
+--- Test modifiers
+---@class TestModifier
+---@field gql (fun(name: string, fn: fun(t: TestFunctionTgql)))|(fun(name: string, opts: TestOptions, fn: fun(t: TestFunctionTgql)))
+---@field sql (fun(name: string, fn: fun(t: TestFunctionTsql)))|(fun(name: string, opts: TestOptions, fn: fun(t: TestFunctionTsql)))
+---@field rest (fun(name: string, fn: fun(t: TestFunctionTrest)))|(fun(name: string, opts: TestOptions, fn: fun(t: TestFunctionTrest)))
+
 --- Test case
 ---@class Test
----@field gql fun(name: string, fn: fun(t: TestFunctionTgql))
----@field sql fun(name: string, fn: fun(t: TestFunctionTsql))
----@field rest fun(name: string, fn: fun(t: TestFunctionTrest))
+---@field gql (fun(name: string, fn: fun(t: TestFunctionTgql)))|(fun(name: string, opts: TestOptions, fn: fun(t: TestFunctionTgql)))
+---@field sql (fun(name: string, fn: fun(t: TestFunctionTsql)))|(fun(name: string, opts: TestOptions, fn: fun(t: TestFunctionTsql)))
+---@field rest (fun(name: string, fn: fun(t: TestFunctionTrest)))|(fun(name: string, opts: TestOptions, fn: fun(t: TestFunctionTrest)))
+---@field skip TestModifier Skip the test
+---@field only TestModifier Only run tests marked with only in this file
+---@field todo fun(name: string) Mark a test as not yet implemented
 Test = {}
 
 --- Helper functions
@@ -2,25 +2,66 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/nais/tester/example/internal/integration"
 	"github.com/nais/tester/lua"
+	"github.com/nais/tester/lua/reporter"
 )
 
 func main() {
 	dir := filepath.Join(".", "internal", "integration", "testdata")
 	ui := false
+	parallel := 1
+	files, run, runners := "", "", ""
+	format := "json"
+	verbose := false
+	timeout := time.Duration(0)
+	update := false
+	gqlCoverage := ""
+	restCoverage := ""
 	flag.StringVar(&dir, "d", dir, "write spec to this directory")
 	flag.BoolVar(&ui, "ui", ui, "enable UI")
+	flag.IntVar(&parallel, "p", parallel, "maximum number of files to run in parallel")
+	flag.StringVar(&files, "files", files, "comma separated list of file globs to run")
+	flag.StringVar(&run, "run", run, "only run tests with names matching this regular expression")
+	flag.StringVar(&runners, "runners", runners, "comma separated list of runners to run, e.g. gql,sql")
+	flag.StringVar(&format, "reporter", format, "output format, one of: json, junit, tap, console")
+	flag.BoolVar(&verbose, "v", verbose, "print info output for passing tests with the console reporter")
+	flag.DurationVar(&timeout, "timeout", timeout, "default timeout for each test, 0 to disable")
+	flag.BoolVar(&update, "update", update, "overwrite snapshots with the actual values")
+	flag.StringVar(&gqlCoverage, "gql-coverage", gqlCoverage, "write the GraphQL schema coverage report as JSON to this file")
+	flag.StringVar(&restCoverage, "rest-coverage", restCoverage, "write the OpenAPI operation coverage report as JSON to this file")
 	flag.Parse()
 
+	filter := lua.Filter{
+		Files:   splitList(files),
+		Runners: splitList(runners),
+	}
+	if run != "" {
+		re, err := regexp.Compile(run)
+		if err != nil {
+			flagError("run", run, err)
+		}
+		filter.Name = re
+	}
+
 	mgr, err := integration.TestRunner(false)
 	if err != nil {
 		panic(err)
 	}
+	mgr.SetMaxParallel(parallel)
+	mgr.SetFilter(filter)
+	mgr.SetTestTimeout(timeout)
+	mgr.SetUpdateSnapshots(update)
 
 	ctx := context.Background()
 
@@ -31,7 +72,71 @@ func main() {
 		return
 	}
 
-	if err := mgr.Run(ctx, dir, lua.NewJSONReporter(os.Stdout)); err != nil {
+	report, err := newReporter(format, verbose, os.Stdout)
+	if err != nil {
 		panic(err)
 	}
+
+	result, runErr := mgr.Run(ctx, dir, report)
+
+	if c, ok := report.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			panic(err)
+		}
+	}
+
+	if gqlCoverage != "" && result != nil {
+		if err := writeJSON(gqlCoverage, result.GQLCoverage); err != nil {
+			panic(err)
+		}
+	}
+
+	if restCoverage != "" && result != nil {
+		if err := writeJSON(restCoverage, result.RestCoverage); err != nil {
+			panic(err)
+		}
+	}
+
+	if runErr != nil {
+		fmt.Fprintln(os.Stderr, runErr)
+		os.Exit(1)
+	}
+}
+
+// flagError prints an invalid flag value and the usage, and exits with the
+// same status as flag.Parse
+func flagError(name, value string, err error) {
+	fmt.Fprintf(flag.CommandLine.Output(), "invalid value %q for flag -%s: %v\n", value, name, err)
+	flag.Usage()
+	os.Exit(2)
+}
+
+func newReporter(format string, verbose bool, w io.Writer) (reporter.Reporter, error) {
+	switch format {
+	case "json":
+		return lua.NewJSONReporter(w), nil
+	case "junit":
+		return lua.NewJUnitReporter(w), nil
+	case "tap":
+		return lua.NewTAPReporter(w), nil
+	case "console":
+		return lua.NewConsoleReporter(w, lua.ConsoleVerbose(verbose)), nil
+	default:
+		return nil, fmt.Errorf("unknown reporter %q", format)
+	}
+}
+
+func writeJSON(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
 }
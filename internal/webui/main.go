@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/fs"
-	"log"
 	"net/http"
 )
 
@@ -16,7 +15,8 @@ var static embed.FS
 type Option func(*options)
 
 type options struct {
-	root fs.FS
+	root     fs.FS
+	coverage func() any
 }
 
 func WithRoot(root fs.FS) Option {
@@ -25,6 +25,13 @@ func WithRoot(root fs.FS) Option {
 	}
 }
 
+// WithCoverage serves the coverage report returned by fn at /coverage
+func WithCoverage(fn func() any) Option {
+	return func(o *options) {
+		o.coverage = fn
+	}
+}
+
 func Run(ctx context.Context, reporter *SSEReporter, opts ...Option) error {
 	o := options{
 		root: static,
@@ -50,10 +57,23 @@ func Run(ctx context.Context, reporter *SSEReporter, opts ...Option) error {
 			return
 		}
 
-		reporter.RequestRerun(filename)
+		reporter.RequestRerun(filename, r.URL.Query().Get("update") == "true")
 		w.WriteHeader(http.StatusAccepted)
 	}))
 
+	mux.Handle("/coverage", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if o.coverage == nil {
+			http.Error(w, "Coverage is not enabled", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if err := json.NewEncoder(w).Encode(o.coverage()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+
 	mux.Handle("/events", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Handle Server sent events
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -63,7 +83,7 @@ func Run(ctx context.Context, reporter *SSEReporter, opts ...Option) error {
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
 
-		ch := make(chan *SSEMessage, 2)
+		ch := make(listener, 2)
 		reporter.cache.AddListener(ch)
 		defer reporter.cache.RemoveListener(ch)
 
@@ -71,12 +91,7 @@ func Run(ctx context.Context, reporter *SSEReporter, opts ...Option) error {
 			select {
 			case <-ctx.Done():
 				return
-			case msg := <-ch:
-				b, err := json.Marshal(msg)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
+			case b := <-ch:
 				fmt.Fprintf(w, "data: %s\n\n", string(b))
 				w.(http.Flusher).Flush()
 			}
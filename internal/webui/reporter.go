@@ -2,7 +2,9 @@ package webui
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log"
 	"path/filepath"
 	"slices"
 	"sync"
@@ -14,6 +16,8 @@ import (
 // RerunRequest represents a request to rerun a test file
 type RerunRequest struct {
 	Filename string
+	// UpdateSnapshots overwrites the snapshots of the file with the actual values
+	UpdateSnapshots bool
 }
 
 type TestInfo struct {
@@ -30,6 +34,7 @@ type TestError struct {
 	Message  string `json:"message"`
 	Expected any    `json:"expected,omitempty"`
 	Actual   any    `json:"actual,omitempty"`
+	Timeout  bool   `json:"timeout,omitempty"`
 }
 
 type Test struct {
@@ -41,6 +46,8 @@ type Test struct {
 	Errors   []*TestError  `json:"errors"`
 	Infos    []*TestInfo   `json:"infos"`
 	Duration time.Duration `json:"duration"`
+	Skipped  string        `json:"skipped,omitempty"`
+	Todo     bool          `json:"todo,omitempty"`
 
 	start time.Time
 	cache *sseCache
@@ -52,6 +59,8 @@ func (t *Test) Start() {
 
 	t.Errors = nil
 	t.Infos = nil
+	t.Skipped = ""
+	t.Todo = false
 	t.start = time.Now()
 
 	t.cache.Broadcast(&SSEMessage{
@@ -84,6 +93,7 @@ func (t *Test) AddError(err *reporter.Error) {
 		Message:  err.Message,
 		Expected: err.Expected,
 		Actual:   err.Actual,
+		Timeout:  err.Timeout,
 	})
 
 	t.cache.Broadcast(&SSEMessage{
@@ -92,6 +102,20 @@ func (t *Test) AddError(err *reporter.Error) {
 	})
 }
 
+func (t *Test) Skip(reason string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.Skipped = reason
+}
+
+func (t *Test) MarkTodo() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.Todo = true
+}
+
 func (t *Test) AddInfo(info reporter.Info) {
 	if t == nil {
 		fmt.Printf("[%s] %s: %s\n", info.Type, info.Title, info.Content)
@@ -201,7 +225,7 @@ type SSEMessage struct {
 	Data any    `json:"data"`
 }
 
-type listener chan *SSEMessage
+type listener chan []byte
 
 type sseCache struct {
 	dirPrefix string
@@ -214,12 +238,21 @@ type sseCache struct {
 	rerunCh chan RerunRequest
 }
 
+// Broadcast encodes the message immediately, while the caller still holds the
+// lock of the data being sent, so files running in parallel never have their
+// state read by the listeners while it's being modified.
 func (c *sseCache) Broadcast(msg *SSEMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 
 	for _, ch := range c.listeners {
-		ch <- msg
+		ch <- b
 	}
 }
 
@@ -238,10 +271,16 @@ func (c *sseCache) AddListener(ch listener) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	ch <- &SSEMessage{
+	b, err := json.Marshal(&SSEMessage{
 		Type: "init",
 		Data: c.files,
+	})
+	if err != nil {
+		log.Println(err)
+		return
 	}
+
+	ch <- b
 	c.listeners = append(c.listeners, ch)
 }
 
@@ -296,11 +335,12 @@ func (r *SSEReporter) RerunChannel() <-chan RerunRequest {
 	return r.cache.rerunCh
 }
 
-// RequestRerun sends a rerun request for the given filename
-func (r *SSEReporter) RequestRerun(filename string) {
+// RequestRerun sends a rerun request for the given filename. If
+// updateSnapshots is true, the snapshots of the file are updated.
+func (r *SSEReporter) RequestRerun(filename string, updateSnapshots bool) {
 	// Convert relative path back to absolute if needed
 	fullPath := filepath.Join(r.cache.dirPrefix, filename)
-	r.cache.rerunCh <- RerunRequest{Filename: fullPath}
+	r.cache.rerunCh <- RerunRequest{Filename: fullPath, UpdateSnapshots: updateSnapshots}
 }
 
 func (r *SSEReporter) RunFile(ctx context.Context, filename string, fn func(reporter.Reporter)) {
@@ -329,6 +369,14 @@ func (r *SSEReporter) Info(info reporter.Info) {
 	}
 }
 
+func (r *SSEReporter) Skip(reason string) {
+	r.test.Skip(reason)
+}
+
+func (r *SSEReporter) Todo() {
+	r.test.MarkTodo()
+}
+
 func (r *SSEReporter) RemoveFile(name string) {
 	r.cache.RemoveFile(name)
 }
@@ -5,9 +5,19 @@ export enum Status {
 	"DONE",
 	"ERROR",
 	"SKIP",
+	"TODO",
 }
 
-export type InfoType = "helper" | "request" | "response" | "query" | "result";
+export type InfoType =
+	| "helper"
+	| "request"
+	| "response"
+	| "query"
+	| "result"
+	| "retry"
+	| "snapshot"
+	| "cookies"
+	| "warning";
 
 export interface InfoArg {
 	name?: string;
@@ -40,9 +50,17 @@ export class SubTest {
 		if (this.errors) {
 			return Status.ERROR;
 		}
+		if (this.todo) {
+			return Status.TODO;
+		}
+		if (this.skipped) {
+			return Status.SKIP;
+		}
 		return Status.DONE;
 	});
 	duration: number = $state(0);
+	skipped: string | undefined = $state();
+	todo: boolean = $state(false);
 	errors: TestError[] | null = $state(null);
 	infos: TestInfo[] = $state([]);
 
@@ -61,6 +79,9 @@ export class File {
 		if (this.subTests.some((subTest) => subTest.status === Status.RUNNING)) {
 			return Status.RUNNING;
 		}
+		if (this.subTests.length > 0 && this.subTests.every((subTest) => subTest.status === Status.SKIP || subTest.status === Status.TODO)) {
+			return Status.SKIP;
+		}
 		return Status.DONE;
 	});
 	subTests: SubTest[] = $state([]);
@@ -86,6 +107,8 @@ type EventSubTest = {
 	errors: TestError[] | null;
 	infos: TestInfo[] | null;
 	duration: number;
+	skipped?: string;
+	todo?: boolean;
 	order?: number;
 };
 
@@ -143,6 +166,8 @@ type Event =
 function createSubTest(subTest: EventSubTest): SubTest {
 	const newSubTest = new SubTest(subTest.name, subTest.order ?? 0);
 	newSubTest.duration = subTest.duration;
+	newSubTest.skipped = subTest.skipped;
+	newSubTest.todo = subTest.todo ?? false;
 	newSubTest.errors = subTest.errors;
 	newSubTest.infos = subTest.infos ?? [];
 	return newSubTest;
@@ -231,6 +256,8 @@ class Watcher {
 
 				if (existingSubTest) {
 					existingSubTest.duration = data.data.duration;
+					existingSubTest.skipped = data.data.skipped;
+					existingSubTest.todo = data.data.todo ?? false;
 					existingSubTest.errors = data.data.errors;
 					existingSubTest.infos = data.data.infos ?? [];
 				} else {
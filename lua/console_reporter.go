@@ -0,0 +1,281 @@
+package lua
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nais/tester/lua/reporter"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorPurple = "\033[35m"
+	colorGray   = "\033[90m"
+)
+
+type ConsoleOption func(*consoleOptions)
+
+type consoleOptions struct {
+	color   bool
+	verbose bool
+}
+
+// ConsoleColor enables or disables ANSI colors. Colors are enabled by default.
+func ConsoleColor(enabled bool) ConsoleOption {
+	return func(o *consoleOptions) {
+		o.color = enabled
+	}
+}
+
+// ConsoleVerbose prints Info output for every test. By default Info output is
+// only printed for failing tests.
+func ConsoleVerbose(enabled bool) ConsoleOption {
+	return func(o *consoleOptions) {
+		o.verbose = enabled
+	}
+}
+
+// ConsoleReporter writes human readable results to a terminal. The output of
+// each file is written when the file is done, so files running in parallel are
+// never interleaved. Call Close after Run to print a summary.
+type ConsoleReporter struct {
+	w    io.Writer
+	opts consoleOptions
+
+	lock    *sync.Mutex
+	summary *consoleSummary
+
+	file *consoleFile
+	test *consoleTest
+}
+
+type consoleSummary struct {
+	passed, failed, skipped int
+	start                   time.Time
+}
+
+type consoleFile struct {
+	out                     bytes.Buffer
+	infos                   []reporter.Info
+	errors                  []*reporter.Error
+	passed, failed, skipped int
+}
+
+type consoleTest struct {
+	infos   []reporter.Info
+	errors  []*reporter.Error
+	skipped string
+	todo    bool
+}
+
+func NewConsoleReporter(w io.Writer, opts ...ConsoleOption) *ConsoleReporter {
+	o := consoleOptions{color: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &ConsoleReporter{
+		w:       w,
+		opts:    o,
+		lock:    &sync.Mutex{},
+		summary: &consoleSummary{start: time.Now()},
+	}
+}
+
+func (r *ConsoleReporter) child(file *consoleFile, test *consoleTest) *ConsoleReporter {
+	return &ConsoleReporter{
+		w:       r.w,
+		opts:    r.opts,
+		lock:    r.lock,
+		summary: r.summary,
+		file:    file,
+		test:    test,
+	}
+}
+
+func (r *ConsoleReporter) RunFile(ctx context.Context, filename string, fn func(reporter.Reporter)) {
+	start := time.Now()
+	file := &consoleFile{}
+
+	fn(r.child(file, nil))
+
+	if len(file.errors) > 0 {
+		file.failed++
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.summary.passed += file.passed
+	r.summary.failed += file.failed
+	r.summary.skipped += file.skipped
+
+	fmt.Fprintf(r.w, "%s\n", r.colorize(colorGray, "=== "+filename))
+	if len(file.errors) > 0 || r.opts.verbose {
+		r.writeInfos(r.w, file.infos, "  ")
+	}
+	for _, err := range file.errors {
+		r.writeError(r.w, err, "  ")
+	}
+	_, _ = file.out.WriteTo(r.w)
+
+	status := r.colorize(colorGreen, "PASS")
+	if file.failed > 0 {
+		status = r.colorize(colorRed, "FAIL")
+	}
+	fmt.Fprintf(r.w, "%s %s: %d passed, %d failed, %d skipped %s\n\n",
+		status, filename, file.passed, file.failed, file.skipped,
+		r.colorize(colorGray, "("+formatDuration(time.Since(start))+")"))
+}
+
+func (r *ConsoleReporter) RunTest(ctx context.Context, runner, name string, fn func(reporter.Reporter)) {
+	start := time.Now()
+	test := &consoleTest{}
+
+	fn(r.child(r.file, test))
+
+	duration := r.colorize(colorGray, "("+formatDuration(time.Since(start))+")")
+	out := &r.file.out
+	switch {
+	case len(test.errors) > 0:
+		r.file.failed++
+		fmt.Fprintf(out, "  %s %s %s\n", r.colorize(colorRed, "✗"), name, duration)
+	case test.todo:
+		r.file.skipped++
+		fmt.Fprintf(out, "  %s %s %s\n", r.colorize(colorPurple, "✎"), name, r.colorize(colorPurple, "(todo)"))
+	case test.skipped != "":
+		r.file.skipped++
+		fmt.Fprintf(out, "  %s %s %s\n", r.colorize(colorYellow, "↷"), name, r.colorize(colorYellow, "("+test.skipped+")"))
+	default:
+		r.file.passed++
+		fmt.Fprintf(out, "  %s %s %s\n", r.colorize(colorGreen, "✓"), name, duration)
+	}
+
+	if len(test.errors) > 0 || r.opts.verbose {
+		r.writeInfos(out, test.infos, "      ")
+	}
+	for _, err := range test.errors {
+		r.writeError(out, err, "      ")
+	}
+}
+
+func (r *ConsoleReporter) ReportError(err *reporter.Error) {
+	switch {
+	case r.test != nil:
+		r.test.errors = append(r.test.errors, err)
+	case r.file != nil:
+		r.file.errors = append(r.file.errors, err)
+	default:
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.summary.failed++
+		r.writeError(r.w, err, "")
+	}
+}
+
+func (r *ConsoleReporter) Info(info reporter.Info) {
+	switch {
+	case r.test != nil:
+		r.test.infos = append(r.test.infos, info)
+	case r.file != nil:
+		r.file.infos = append(r.file.infos, info)
+	}
+}
+
+func (r *ConsoleReporter) Skip(reason string) {
+	r.test.skipped = reason
+}
+
+func (r *ConsoleReporter) Todo() {
+	r.test.todo = true
+}
+
+// Close prints a summary of all files run.
+func (r *ConsoleReporter) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	status := r.colorize(colorGreen, "PASS")
+	if r.summary.failed > 0 {
+		status = r.colorize(colorRed, "FAIL")
+	}
+	_, err := fmt.Fprintf(r.w, "%s: %d passed, %d failed, %d skipped (%s)\n",
+		status, r.summary.passed, r.summary.failed, r.summary.skipped, formatDuration(time.Since(r.summary.start)))
+	return err
+}
+
+func (r *ConsoleReporter) writeInfos(w io.Writer, infos []reporter.Info, indent string) {
+	for _, info := range infos {
+		fmt.Fprintf(w, "%s%s\n", indent, r.colorize(colorGray, fmt.Sprintf("[%s] %s", info.Type, info.Title)))
+		for _, arg := range info.Args {
+			if arg.Name != "" {
+				fmt.Fprintf(w, "%s  %s: %s\n", indent, arg.Name, arg.Value)
+			} else {
+				fmt.Fprintf(w, "%s  %s\n", indent, arg.Value)
+			}
+		}
+		if info.Content != "" {
+			writeIndented(w, info.Content, indent+"  ")
+		}
+	}
+}
+
+func (r *ConsoleReporter) writeError(w io.Writer, err *reporter.Error, indent string) {
+	if err.Expected == nil && err.Actual == nil {
+		writeIndented(w, r.colorize(colorRed, err.Message), indent)
+		return
+	}
+
+	diff := unifiedDiff(err.Expected, err.Actual)
+	if diff == "" {
+		writeIndented(w, r.colorize(colorRed, err.Message), indent)
+		return
+	}
+
+	fmt.Fprintf(w, "%s%s\n", indent, r.colorize(colorRed, "check failed:"))
+	for line := range strings.SplitSeq(strings.TrimSuffix(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			line = r.colorize(colorGray, line)
+		case strings.HasPrefix(line, "-"):
+			line = r.colorize(colorRed, line)
+		case strings.HasPrefix(line, "+"):
+			line = r.colorize(colorGreen, line)
+		case strings.HasPrefix(line, "@@"):
+			line = r.colorize(colorPurple, line)
+		}
+		fmt.Fprintf(w, "%s%s\n", indent, line)
+	}
+}
+
+func (r *ConsoleReporter) colorize(color, s string) string {
+	if !r.opts.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func writeIndented(w io.Writer, s, indent string) {
+	for line := range strings.SplitSeq(s, "\n") {
+		fmt.Fprintf(w, "%s%s\n", indent, line)
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	default:
+		return d.Round(10 * time.Millisecond).String()
+	}
+}
@@ -0,0 +1,209 @@
+package lua
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3
+
+// unifiedDiff returns a unified diff between the JSON representation of
+// expected and actual. It returns an empty string if they are equal.
+func unifiedDiff(expected, actual any) string {
+	a := jsonLines(expected)
+	b := jsonLines(actual)
+
+	ops := diffLines(a, b)
+
+	// Find the ranges of operations that should be part of a hunk
+	var sb strings.Builder
+	sb.WriteString("--- expected\n+++ actual\n")
+	hasChanges := false
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		hasChanges = true
+
+		start := max(i-diffContext, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			// Look ahead to see if the next change is within reach of the context
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > diffContext*2 {
+				end = min(end+diffContext, len(ops))
+				break
+			}
+			end = next
+		}
+
+		writeHunk(&sb, ops[start:end])
+		i = end
+	}
+
+	if !hasChanges {
+		return ""
+	}
+	return sb.String()
+}
+
+type diffOp struct {
+	kind  byte // ' ', '-' or '+'
+	line  string
+	aLine int
+	bLine int
+}
+
+func writeHunk(sb *strings.Builder, ops []diffOp) {
+	aStart, bStart := -1, -1
+	aCount, bCount := 0, 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			if aStart < 0 {
+				aStart = op.aLine
+			}
+			aCount++
+		}
+		if op.kind != '-' {
+			if bStart < 0 {
+				bStart = op.bLine
+			}
+			bCount++
+		}
+	}
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", aStart+1, aCount, bStart+1, bCount)
+	for _, op := range ops {
+		sb.WriteByte(op.kind)
+		sb.WriteString(op.line)
+		sb.WriteByte('\n')
+	}
+}
+
+// diffLines computes the edit script between a and b using Myers' diff
+// algorithm, with the linear space refinement so large documents don't need
+// a table of every pair of lines.
+func diffLines(a, b []string) []diffOp {
+	d := &differ{a: a, b: b}
+	d.compare(0, len(a), 0, len(b))
+	return d.ops
+}
+
+type differ struct {
+	a, b []string
+	ops  []diffOp
+}
+
+func (d *differ) equal(aLo, aHi, bLo int) {
+	for i := aLo; i < aHi; i++ {
+		j := bLo + i - aLo
+		d.ops = append(d.ops, diffOp{kind: ' ', line: d.a[i], aLine: i, bLine: j})
+	}
+}
+
+// compare appends the edit script between a[aLo:aHi] and b[bLo:bHi]
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	prefix := 0
+	for aLo+prefix < aHi && bLo+prefix < bHi && d.a[aLo+prefix] == d.b[bLo+prefix] {
+		prefix++
+	}
+	d.equal(aLo, aLo+prefix, bLo)
+	aLo, bLo = aLo+prefix, bLo+prefix
+
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-suffix-1] == d.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi, bHi = aHi-suffix, bHi-suffix
+
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.ops = append(d.ops, diffOp{kind: '+', line: d.b[j], aLine: aLo, bLine: j})
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.ops = append(d.ops, diffOp{kind: '-', line: d.a[i], aLine: i, bLine: bLo})
+		}
+	default:
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		d.equal(x, u, y)
+		d.compare(u, aHi, v, bHi)
+	}
+
+	d.equal(aHi, aHi+suffix, bHi)
+}
+
+// middleSnake finds the middle snake of the shortest edit script between
+// a[aLo:aHi] and b[bLo:bHi], by searching forward and backward at the same
+// time. It returns the start and end of the snake. Both ranges must be non
+// empty, and differ in their first and last lines.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	maxD := (n + m + 1) / 2
+	off := maxD + 1
+	// forward[k] is the furthest x on diagonal k = x - y from the start, and
+	// backward[k] the furthest x on diagonal k from the end
+	forward := make([]int, 2*maxD+3)
+	backward := make([]int, 2*maxD+3)
+
+	for dd := 0; dd <= maxD; dd++ {
+		for k := -dd; k <= dd; k += 2 {
+			var x int
+			if k == -dd || (k != dd && forward[off+k-1] < forward[off+k+1]) {
+				x = forward[off+k+1]
+			} else {
+				x = forward[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[off+k] = x
+			if odd && k >= delta-(dd-1) && k <= delta+(dd-1) && x+backward[off+delta-k] >= n {
+				return aLo + sx, bLo + sy, aLo + x, bLo + y
+			}
+		}
+
+		for k := -dd; k <= dd; k += 2 {
+			var x int
+			if k == -dd || (k != dd && backward[off+k-1] < backward[off+k+1]) {
+				x = backward[off+k+1]
+			} else {
+				x = backward[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			backward[off+k] = x
+			if !odd && delta-k >= -dd && delta-k <= dd && x+forward[off+delta-k] >= n {
+				return aLo + n - x, bLo + m - y, aLo + n - sx, bLo + m - sy
+			}
+		}
+	}
+	panic("diff: no middle snake found")
+}
+
+func jsonLines(v any) []string {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return strings.Split(fmt.Sprintf("%v", v), "\n")
+	}
+	return strings.Split(string(b), "\n")
+}
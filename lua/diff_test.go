@@ -0,0 +1,82 @@
+package lua
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestDiffLinesIsMinimal(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	lines := func() []string {
+		ret := make([]string, rnd.IntN(12))
+		for i := range ret {
+			ret[i] = strconv.Itoa(rnd.IntN(4))
+		}
+		return ret
+	}
+
+	for range 2000 {
+		a, b := lines(), lines()
+		ops := diffLines(a, b)
+
+		var gotA, gotB []string
+		edits := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.line)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+			t.Fatalf("edit script of %q and %q doesn't reproduce them: %+v", a, b, ops)
+		}
+		if want := len(a) + len(b) - 2*lcsLength(a, b); edits != want {
+			t.Fatalf("edit script of %q and %q has %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+func TestDiffLinesLargeInput(t *testing.T) {
+	a := make([]string, 20000)
+	b := make([]string, 20000)
+	for i := range a {
+		a[i] = strconv.Itoa(i)
+		b[i] = strconv.Itoa(i)
+		if i%1000 == 0 {
+			b[i] = "changed"
+		}
+	}
+
+	edits := 0
+	for _, op := range diffLines(a, b) {
+		if op.kind != ' ' {
+			edits++
+		}
+	}
+	if edits != 40 {
+		t.Errorf("expected 40 edits, got %d", edits)
+	}
+}
+
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,75 @@
+package lua
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nais/tester/lua/reporter"
+	"github.com/nais/tester/lua/runner"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	defaultEventuallyTimeout  = 5 * time.Second
+	defaultEventuallyInterval = 100 * time.Millisecond
+)
+
+// eventually calls the function given as the first argument until it returns
+// without raising an error, or the timeout expires. Every failed attempt is
+// logged as info.
+//
+//	Eventually(function() t.check("topic", { ... }) end, { timeout = "2s", interval = "50ms" })
+func eventually(L *lua.LState) int {
+	fn := L.CheckFunction(1)
+	opts := L.OptTable(2, L.NewTable())
+
+	timeout := defaultEventuallyTimeout
+	interval := defaultEventuallyInterval
+	opts.ForEach(func(k, v lua.LValue) {
+		switch key := lua.LVAsString(k); key {
+		case "timeout":
+			timeout = luaDuration(L, key, v)
+		case "interval":
+			interval = luaDuration(L, key, v)
+		default:
+			L.RaiseError("unknown Eventually option %q", key)
+		}
+	})
+
+	ctx := L.Context()
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		// Reset the context, as a failing check stores its error in the context
+		L.SetContext(ctx)
+		err := L.CallByParam(lua.P{
+			Fn:      fn,
+			Protect: true,
+		})
+		if err == nil {
+			if attempt > 1 {
+				runner.Info(ctx, reporter.Info{
+					Type:  reporter.InfoTypeRetry,
+					Title: fmt.Sprintf("Eventually succeeded after %d attempts", attempt),
+				})
+			}
+			return 0
+		}
+
+		runner.Info(ctx, reporter.Info{
+			Type:    reporter.InfoTypeRetry,
+			Title:   fmt.Sprintf("Eventually attempt %d failed", attempt),
+			Content: err.Error(),
+		})
+
+		if time.Now().Add(interval).After(deadline) {
+			// The context of the last attempt is kept, so a check error is reported with its diff
+			L.RaiseError("condition not met within %s after %d attempts: %v", timeout, attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			L.RaiseError("condition not met after %d attempts: %v", attempt, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
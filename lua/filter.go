@@ -0,0 +1,53 @@
+package lua
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+)
+
+// Filter selects a subset of files and tests to run. Zero values match
+// everything.
+type Filter struct {
+	// Files is a list of glob patterns (see filepath.Match). A file is run if
+	// any of the patterns match either its path relative to the test directory
+	// or its base name.
+	Files []string
+	// Name is matched against the name of each test.
+	Name *regexp.Regexp
+	// Runners is a list of runner names, e.g. "gql" or "sql".
+	Runners []string
+}
+
+// matchFile reports whether the file should be run. rel is the path relative to
+// the test directory.
+func (f Filter) matchFile(rel string) (bool, error) {
+	if len(f.Files) == 0 {
+		return true, nil
+	}
+
+	for _, pattern := range f.Files {
+		for _, name := range []string{filepath.ToSlash(rel), filepath.Base(rel)} {
+			ok, err := filepath.Match(pattern, name)
+			if err != nil {
+				return false, fmt.Errorf("invalid file pattern %q: %w", pattern, err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// skipReason returns a non-empty reason if the test should be skipped.
+func (f Filter) skipReason(runner, name string) string {
+	if len(f.Runners) > 0 && !slices.Contains(f.Runners, runner) {
+		return fmt.Sprintf("runner %q not selected", runner)
+	}
+	if f.Name != nil && !f.Name.MatchString(name) {
+		return fmt.Sprintf("name does not match %q", f.Name.String())
+	}
+	return ""
+}
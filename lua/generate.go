@@ -8,7 +8,6 @@ import (
 	"strings"
 
 	"github.com/nais/tester/lua/spec"
-	lua "github.com/yuin/gopher-lua"
 )
 
 const specFilename = "zz_spec.lua"
@@ -43,6 +42,159 @@ function NotNull()
 	return {}
 end
 
+--- Ensure the field is a string matching the regular expression. Uses Go regexp syntax
+---@param regex string
+---@return userdata
+function Matches(regex)
+  print("Matches: ", regex)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a number between min and max, inclusive
+---@param min number
+---@param max number
+---@return userdata
+function Between(min, max)
+  print("Between: ", min, max)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a number greater than n
+---@param n number
+---@return userdata
+function GreaterThan(n)
+  print("GreaterThan: ", n)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a number less than n
+---@param n number
+---@return userdata
+function LessThan(n)
+  print("LessThan: ", n)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is of the given type. A table is either a list or a map
+---@param type "string"|"number"|"boolean"|"table"|"list"|"map"|"null"
+---@return userdata
+function IsType(type)
+  print("IsType: ", type)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is equal to one of the values
+---@param ... string|number|boolean
+---@return userdata
+function OneOf(...)
+  print("OneOf: ", ...)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is an RFC3339 timestamp, or a date on the form YYYY-MM-DD
+---@return userdata
+function ISODate()
+  print("ISODate")
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a list containing exactly the given elements, in any order
+---@param elements any[]
+---@return userdata
+function Unordered(elements)
+  print("Unordered: ", elements)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a list containing the given elements, in any order. Other elements are allowed
+---@param elements any[]
+---@return userdata
+function ContainsElements(elements)
+  print("ContainsElements: ", elements)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a map with the given fields. Other fields are ignored
+---@param fields table<string, any>
+---@return userdata
+function Partial(fields)
+  print("Partial: ", fields)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a list, map or string of the given length
+---@param n integer
+---@return userdata
+function Length(n)
+  print("Length: ", n)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure fn returns true when called with the field
+---@param fn fun(v: any): boolean
+---@return userdata
+function Satisfies(fn)
+  print("Satisfies: ", fn)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Compare the value against the snapshot with the given name. The snapshot is written
+--- with the actual value if it doesn't exist, or if snapshots are being updated.
+--- Fields in overrides replace the snapshot, e.g. to use Ignore() or NotNull() for dynamic fields.
+--- An override list with a single element is applied to every element in the list.
+---@param name string Name of the snapshot, unique within the file
+---@param overrides? table
+---@return userdata
+function MatchSnapshot(name, overrides)
+  print("MatchSnapshot: ", name, overrides)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Call fn until it no longer raises an error, or the timeout expires.
+--- Every failed attempt is logged.
+---@param fn fun()
+---@param opts? {timeout?: string|number, interval?: string|number} Defaults to a timeout of 5s and an interval of 100ms
+function Eventually(fn, opts)
+  print("Eventually: ", fn, opts)
+end
+
+--- Run fn once before the next test in the file. If it fails, the remaining tests are skipped
+---@param fn fun()
+function BeforeAll(fn)
+  print("BeforeAll: ", fn)
+end
+
+--- Run fn before each test declared after this call. It receives the same argument as the test function
+---@param fn fun(t: any)
+function BeforeEach(fn)
+  print("BeforeEach: ", fn)
+end
+
+--- Run fn after each test declared after this call, even if the test failed. It receives the same argument as the test function
+---@param fn fun(t: any)
+function AfterEach(fn)
+  print("AfterEach: ", fn)
+end
+
+--- Run fn once after all tests in the file are done
+---@param fn fun()
+function AfterAll(fn)
+  print("AfterAll: ", fn)
+end
+
 --- Save the field to the state. By default it will error if the field is null
 ---@param name string Name of the field in the state
 ---@param allowNull? boolean
@@ -62,26 +214,52 @@ State = {}
 ---@diagnostic disable-next-line: assign-type-mismatch
 Null = {}
 
+--- Options for a single test
+---@class TestOptions
+---@field timeout? string|number Maximum duration of the test, e.g. "5s". Numbers are seconds
+---@field retry? integer Number of times to run a failing test again
+
 `
 
-func GenerateSpec(w io.Writer, runners []spec.Runner, cfg any, extraHelpers []*spec.Function, metaTypes []*spec.Typemetatable) {
+// GenerateSpec writes the Lua spec for the runners, helpers and matchers to w.
+// Nothing is written if the spec can't be generated.
+func GenerateSpec(w io.Writer, runners []spec.Runner, cfg any, extraHelpers []*spec.Function, metaTypes []*spec.Typemetatable, matchers []spec.Matcher) error {
 	sb := &strings.Builder{}
 	sb.WriteString(base)
 
+	for _, m := range matchers {
+		writeMatcher(sb, m)
+	}
+
 	for _, r := range runners {
 		specForRunner(sb, r)
 	}
 
-	sb.WriteString("--- Test case\n---@class Test\n")
 	for _, r := range runners {
-		sb.WriteString("---@field " + r.Name() + " fun(name: string, fn: fun(t: TestFunctionT" + r.Name() + "))\n")
+		if t, ok := r.(spec.HasTypeAnnotations); ok {
+			annotations, err := t.TypeAnnotations()
+			if err != nil {
+				return fmt.Errorf("type annotations for %s: %w", r.Name(), err)
+			}
+			sb.WriteString(annotations)
+		}
 	}
 
+	sb.WriteString("--- Test modifiers\n---@class TestModifier\n")
+	writeTestFields(sb, runners)
+	sb.WriteString("\n")
+
+	sb.WriteString("--- Test case\n---@class Test\n")
+	writeTestFields(sb, runners)
+	sb.WriteString("---@field skip TestModifier Skip the test\n")
+	sb.WriteString("---@field only TestModifier Only run tests marked with only in this file\n")
+	sb.WriteString("---@field todo fun(name: string) Mark a test as not yet implemented\n")
+
 	sb.WriteString("Test = {}")
 
 	helpers, err := combineHelpers(runners)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	helpers = append(helpers, extraHelpers...)
@@ -105,7 +283,15 @@ func GenerateSpec(w io.Writer, runners []spec.Runner, cfg any, extraHelpers []*s
 	writeConfig(sb, cfg)
 
 	results := strings.TrimSpace(sb.String()) + "\n"
-	_, _ = w.Write([]byte(results))
+	_, err = w.Write([]byte(results))
+	return err
+}
+
+func writeTestFields(sb *strings.Builder, runners []spec.Runner) {
+	for _, r := range runners {
+		fn := "fun(t: TestFunctionT" + r.Name() + ")"
+		sb.WriteString("---@field " + r.Name() + " (fun(name: string, fn: " + fn + "))|(fun(name: string, opts: TestOptions, fn: " + fn + "))\n")
+	}
 }
 
 func specForRunner(sb *strings.Builder, r spec.Runner) {
@@ -167,7 +353,7 @@ func writeFunc(sb *strings.Builder, scope string, f *spec.Function) {
 			sb.WriteString("{}")
 		default:
 			switch f.Returns[0].(type) {
-			case spec.ArgumentTypeTableLiteral, spec.ArgumentTypeArray:
+			case spec.ArgumentTypeTableLiteral, spec.ArgumentTypeArray, spec.ArgumentTypeMetatable:
 				sb.WriteString("{}")
 			}
 		}
@@ -178,6 +364,35 @@ func writeFunc(sb *strings.Builder, scope string, f *spec.Function) {
 	sb.WriteString("end\n\n")
 }
 
+func writeMatcher(sb *strings.Builder, m spec.Matcher) {
+	sb.WriteString("--- " + m.Doc() + "\n")
+	names := make([]string, len(m.Args()))
+	for i, a := range m.Args() {
+		types := make([]string, len(a.Type))
+		for j, t := range a.Type {
+			types[j] = t.String()
+		}
+		sb.WriteString("---@param " + a.Name + " " + strings.Join(types, "|"))
+		if a.Doc != "" {
+			sb.WriteString(" " + a.Doc)
+		}
+		sb.WriteString("\n")
+		names[i] = strings.TrimRight(a.Name, "?")
+	}
+	sb.WriteString("---@return userdata\n")
+
+	args := strings.Join(names, ", ")
+	sb.WriteString("function " + m.Name() + "(" + args + ")\n")
+	if args != "" {
+		sb.WriteString("  print(\"" + m.Name() + ": \", " + args + ")\n")
+	} else {
+		sb.WriteString("  print(\"" + m.Name() + "\")\n")
+	}
+	sb.WriteString("  ---@diagnostic disable-next-line: return-type-mismatch\n")
+	sb.WriteString("  return {}\n")
+	sb.WriteString("end\n\n")
+}
+
 func combineHelpers(r []spec.Runner) ([]*spec.Function, error) {
 	var funcs []*spec.Function
 	for _, runner := range r {
@@ -201,40 +416,6 @@ func combineHelpers(r []spec.Runner) ([]*spec.Function, error) {
 	return funcs, nil
 }
 
-func ConvertToGoType(v lua.LValue) any {
-	switch v.Type() {
-	case lua.LTNil:
-		return nil
-	case lua.LTBool:
-		return lua.LVAsBool(v)
-	case lua.LTNumber:
-		return lua.LVAsNumber(v)
-	case lua.LTString:
-		return lua.LVAsString(v)
-	case lua.LTTable:
-		tbl := v.(*lua.LTable)
-		if tbl.Len() == 0 {
-			// Treat as map
-			m := make(map[any]any, tbl.Len())
-			tbl.ForEach(func(k, v lua.LValue) {
-				m[ConvertToGoType(k)] = ConvertToGoType(v)
-			})
-
-			return m
-		} else {
-			// Treat as list
-			l := make([]any, 0, tbl.Len())
-			tbl.ForEach(func(_, v lua.LValue) {
-				l = append(l, ConvertToGoType(v))
-			})
-
-			return l
-		}
-	default:
-		panic("unknown type: " + v.Type().String())
-	}
-}
-
 func writeConfig(sb *strings.Builder, cfg any) {
 	if cfg == nil {
 		return
@@ -2,6 +2,10 @@ package lua
 
 import (
 	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -15,9 +19,31 @@ type config struct {
 	Supported bool
 }
 
+type prefixMatcher struct{}
+
+func (m *prefixMatcher) Name() string {
+	return "HasPrefix"
+}
+
+func (m *prefixMatcher) Doc() string {
+	return "Ensure the field is a string with the given prefix"
+}
+
+func (m *prefixMatcher) Args() []spec.Argument {
+	return []spec.Argument{
+		{Name: "prefix", Type: []spec.ArgumentType{spec.ArgumentTypeString}, Doc: "The prefix"},
+	}
+}
+
+func (m *prefixMatcher) Match(args []any, actual any) bool {
+	prefix, _ := args[0].(string)
+	s, ok := actual.(string)
+	return ok && strings.HasPrefix(s, prefix)
+}
+
 func TestGenerate(t *testing.T) {
 	buf := &bytes.Buffer{}
-	GenerateSpec(buf, []spec.Runner{
+	err := GenerateSpec(buf, []spec.Runner{
 		&GQLRunner{},
 		&RESTRunner{},
 	}, &config{Supported: true, Other: 42, Field: "test"}, []*spec.Function{
@@ -29,7 +55,10 @@ func TestGenerate(t *testing.T) {
 			Doc:  "Custom helper function",
 			Func: func(L *lua.LState) int { return 0 },
 		},
-	}, nil)
+	}, nil, []spec.Matcher{&prefixMatcher{}})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	expected := `-- This file is generated. Do not edit.
 
@@ -60,6 +89,159 @@ function NotNull()
 	return {}
 end
 
+--- Ensure the field is a string matching the regular expression. Uses Go regexp syntax
+---@param regex string
+---@return userdata
+function Matches(regex)
+  print("Matches: ", regex)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a number between min and max, inclusive
+---@param min number
+---@param max number
+---@return userdata
+function Between(min, max)
+  print("Between: ", min, max)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a number greater than n
+---@param n number
+---@return userdata
+function GreaterThan(n)
+  print("GreaterThan: ", n)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a number less than n
+---@param n number
+---@return userdata
+function LessThan(n)
+  print("LessThan: ", n)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is of the given type. A table is either a list or a map
+---@param type "string"|"number"|"boolean"|"table"|"list"|"map"|"null"
+---@return userdata
+function IsType(type)
+  print("IsType: ", type)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is equal to one of the values
+---@param ... string|number|boolean
+---@return userdata
+function OneOf(...)
+  print("OneOf: ", ...)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is an RFC3339 timestamp, or a date on the form YYYY-MM-DD
+---@return userdata
+function ISODate()
+  print("ISODate")
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a list containing exactly the given elements, in any order
+---@param elements any[]
+---@return userdata
+function Unordered(elements)
+  print("Unordered: ", elements)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a list containing the given elements, in any order. Other elements are allowed
+---@param elements any[]
+---@return userdata
+function ContainsElements(elements)
+  print("ContainsElements: ", elements)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a map with the given fields. Other fields are ignored
+---@param fields table<string, any>
+---@return userdata
+function Partial(fields)
+  print("Partial: ", fields)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure the field is a list, map or string of the given length
+---@param n integer
+---@return userdata
+function Length(n)
+  print("Length: ", n)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Ensure fn returns true when called with the field
+---@param fn fun(v: any): boolean
+---@return userdata
+function Satisfies(fn)
+  print("Satisfies: ", fn)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Compare the value against the snapshot with the given name. The snapshot is written
+--- with the actual value if it doesn't exist, or if snapshots are being updated.
+--- Fields in overrides replace the snapshot, e.g. to use Ignore() or NotNull() for dynamic fields.
+--- An override list with a single element is applied to every element in the list.
+---@param name string Name of the snapshot, unique within the file
+---@param overrides? table
+---@return userdata
+function MatchSnapshot(name, overrides)
+  print("MatchSnapshot: ", name, overrides)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
+--- Call fn until it no longer raises an error, or the timeout expires.
+--- Every failed attempt is logged.
+---@param fn fun()
+---@param opts? {timeout?: string|number, interval?: string|number} Defaults to a timeout of 5s and an interval of 100ms
+function Eventually(fn, opts)
+  print("Eventually: ", fn, opts)
+end
+
+--- Run fn once before the next test in the file. If it fails, the remaining tests are skipped
+---@param fn fun()
+function BeforeAll(fn)
+  print("BeforeAll: ", fn)
+end
+
+--- Run fn before each test declared after this call. It receives the same argument as the test function
+---@param fn fun(t: any)
+function BeforeEach(fn)
+  print("BeforeEach: ", fn)
+end
+
+--- Run fn after each test declared after this call, even if the test failed. It receives the same argument as the test function
+---@param fn fun(t: any)
+function AfterEach(fn)
+  print("AfterEach: ", fn)
+end
+
+--- Run fn once after all tests in the file are done
+---@param fn fun()
+function AfterAll(fn)
+  print("AfterAll: ", fn)
+end
+
 --- Save the field to the state. By default it will error if the field is null
 ---@param name string Name of the field in the state
 ---@param allowNull? boolean
@@ -79,6 +261,20 @@ State = {}
 ---@diagnostic disable-next-line: assign-type-mismatch
 Null = {}
 
+--- Options for a single test
+---@class TestOptions
+---@field timeout? string|number Maximum duration of the test, e.g. "5s". Numbers are seconds
+---@field retry? integer Number of times to run a failing test again
+
+--- Ensure the field is a string with the given prefix
+---@param prefix string The prefix
+---@return userdata
+function HasPrefix(prefix)
+  print("HasPrefix: ", prefix)
+  ---@diagnostic disable-next-line: return-type-mismatch
+  return {}
+end
+
 ---@class TestFunctionTgql
 local TestFunctionTgql = {}
 
@@ -112,10 +308,18 @@ function TestFunctionTrest.check(statusCode, resp)
   print("check")
 end
 
+--- Test modifiers
+---@class TestModifier
+---@field gql (fun(name: string, fn: fun(t: TestFunctionTgql)))|(fun(name: string, opts: TestOptions, fn: fun(t: TestFunctionTgql)))
+---@field rest (fun(name: string, fn: fun(t: TestFunctionTrest)))|(fun(name: string, opts: TestOptions, fn: fun(t: TestFunctionTrest)))
+
 --- Test case
 ---@class Test
----@field gql fun(name: string, fn: fun(t: TestFunctionTgql))
----@field rest fun(name: string, fn: fun(t: TestFunctionTrest))
+---@field gql (fun(name: string, fn: fun(t: TestFunctionTgql)))|(fun(name: string, opts: TestOptions, fn: fun(t: TestFunctionTgql)))
+---@field rest (fun(name: string, fn: fun(t: TestFunctionTrest)))|(fun(name: string, opts: TestOptions, fn: fun(t: TestFunctionTrest)))
+---@field skip TestModifier Skip the test
+---@field only TestModifier Only run tests marked with only in this file
+---@field todo fun(name: string) Mark a test as not yet implemented
 Test = {}
 
 --- Helper functions
@@ -167,6 +371,35 @@ Config = {
 	}
 }
 
+// brokenSchemaRunner has type annotations from a schema that can't be loaded
+type brokenSchemaRunner struct{ GQLRunner }
+
+func (r *brokenSchemaRunner) TypeAnnotations() (string, error) {
+	return "", errors.New("unable to read schema")
+}
+
+func TestGenerateSpecKeepsFileOnError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, specFilename)
+	if err := os.WriteFile(path, []byte("-- old spec\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := newTestManager(t, &brokenSchemaRunner{})
+	err := mgr.GenerateSpec(dir)
+	if err == nil || !strings.Contains(err.Error(), "unable to read schema") {
+		t.Fatalf("expected schema error, got %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "-- old spec\n" {
+		t.Errorf("expected the existing spec to be kept, got:\n%s", b)
+	}
+}
+
 // Some comment about the runner
 type GQLRunner struct{}
 
@@ -217,7 +450,10 @@ func (r *GQLRunner) check(L *lua.LState) int {
 	var resp any
 	switch v.Type() {
 	case lua.LTTable:
-		resp = ConvertToGoType(v)
+		var err error
+		if resp, err = spec.ToGoValue(v); err != nil {
+			L.ArgError(1, err.Error())
+		}
 	case lua.LTString:
 		resp = lua.LVAsString(v)
 	default:
@@ -297,7 +533,11 @@ func (r *RESTRunner) check(L *lua.LState) int {
 	statusCode := L.CheckInt(1)
 	resp := L.CheckTable(2)
 
-	r.Check(statusCode, ConvertToGoType(resp))
+	v, err := spec.ToGoValue(resp)
+	if err != nil {
+		L.ArgError(2, err.Error())
+	}
+	r.Check(statusCode, v)
 	return 0
 }
 
@@ -0,0 +1,93 @@
+package lua
+
+import (
+	"fmt"
+
+	"github.com/nais/tester/lua/reporter"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// hooks holds the lifecycle hooks registered by a Lua file
+type hooks struct {
+	// beforeAll contains BeforeAll hooks that have not run yet. They are run
+	// before the next test, so hooks registered between tests work as expected.
+	beforeAll  []*lua.LFunction
+	beforeEach []*lua.LFunction
+	afterEach  []*lua.LFunction
+	afterAll   []*lua.LFunction
+
+	// beforeAllErr is set if a BeforeAll hook failed. Any following test is skipped.
+	beforeAllErr error
+}
+
+// hookError is returned when a hook raises an error, to attribute the error to the hook
+type hookError struct {
+	hook string
+	err  error
+}
+
+func (e *hookError) Error() string {
+	return fmt.Sprintf("%s hook failed: %v", e.hook, e.err)
+}
+
+func (e *hookError) Unwrap() error {
+	return e.err
+}
+
+func (s *suite) registerHooks(L *lua.LState) {
+	register := func(name string, list *[]*lua.LFunction) {
+		L.SetGlobal(name, L.NewFunction(func(L *lua.LState) int {
+			*list = append(*list, L.CheckFunction(1))
+			return 0
+		}))
+	}
+
+	register("BeforeAll", &s.hooks.beforeAll)
+	register("BeforeEach", &s.hooks.beforeEach)
+	register("AfterEach", &s.hooks.afterEach)
+	register("AfterAll", &s.hooks.afterAll)
+}
+
+// callHooks calls each hook with the given arguments, stopping at the first error
+func callHooks(L *lua.LState, name string, fns []*lua.LFunction, args ...lua.LValue) error {
+	for _, fn := range fns {
+		err := L.CallByParam(lua.P{
+			Fn:      fn,
+			Protect: true,
+		}, args...)
+		if err != nil {
+			return &hookError{hook: name, err: err}
+		}
+	}
+	return nil
+}
+
+// runBeforeAll runs the pending BeforeAll hooks in the file context. It returns
+// the error of the first failing BeforeAll hook, including hooks that failed
+// before a previous test.
+func (s *suite) runBeforeAll(L *lua.LState) error {
+	if s.hooks.beforeAllErr != nil {
+		return s.hooks.beforeAllErr
+	}
+
+	pending := s.hooks.beforeAll
+	s.hooks.beforeAll = nil
+	if err := callHooks(L, "BeforeAll", pending); err != nil {
+		s.hooks.beforeAllErr = err
+		s.reporter.ReportError(reporter.NewError("%s", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// runAfterAll runs the AfterAll hooks when the file is done. The hooks are only
+// run if the setup function has been called, as there's nothing to clean up otherwise.
+func (s *suite) runAfterAll(L *lua.LState) {
+	if !s.setupDone || len(s.hooks.afterAll) == 0 {
+		return
+	}
+
+	if err := callHooks(L, "AfterAll", s.hooks.afterAll); err != nil {
+		s.reporter.ReportError(reporter.NewError("%s", err.Error()))
+	}
+}
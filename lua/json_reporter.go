@@ -4,19 +4,33 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"sync"
 
 	"github.com/nais/tester/lua/reporter"
 )
 
+// JSONReporter writes one JSON object per line. It is safe to use when files
+// are run in parallel, but lines from different files may be interleaved.
 type JSONReporter struct {
 	file   string
 	name   string
 	runner string
-	w      *json.Encoder
+	w      *jsonEncoder
+}
+
+type jsonEncoder struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+func (e *jsonEncoder) Encode(v any) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.enc.Encode(v)
 }
 
 func NewJSONReporter(w io.Writer) *JSONReporter {
-	return &JSONReporter{w: json.NewEncoder(w)}
+	return &JSONReporter{w: &jsonEncoder{enc: json.NewEncoder(w)}}
 }
 
 func (r *JSONReporter) RunFile(ctx context.Context, filename string, fn func(reporter.Reporter)) {
@@ -68,3 +82,22 @@ func (r *JSONReporter) Info(info reporter.Info) {
 		"runner": r.runner,
 	})
 }
+
+func (r *JSONReporter) Skip(reason string) {
+	_ = r.w.Encode(map[string]any{
+		"action": "skip",
+		"reason": reason,
+		"file":   r.file,
+		"name":   r.name,
+		"runner": r.runner,
+	})
+}
+
+func (r *JSONReporter) Todo() {
+	_ = r.w.Encode(map[string]any{
+		"action": "todo",
+		"file":   r.file,
+		"name":   r.name,
+		"runner": r.runner,
+	})
+}
@@ -0,0 +1,239 @@
+package lua
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nais/tester/lua/reporter"
+)
+
+// JUnitReporter collects the results of all files and writes them as JUnit XML
+// when Close is called. Every Lua file becomes a testsuite, and every test a
+// testcase.
+type JUnitReporter struct {
+	w      io.Writer
+	lock   *sync.Mutex
+	suites *[]*junitTestSuite
+
+	suite *junitTestSuite
+	test  *junitTestCase
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Time     float64           `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Time      float64          `xml:"time,attr"`
+	Timestamp string           `xml:"timestamp,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+	SystemOut *junitOutput     `xml:"system-out,omitempty"`
+
+	output strings.Builder
+}
+
+type junitTestCase struct {
+	Name       string           `xml:"name,attr"`
+	Classname  string           `xml:"classname,attr"`
+	Time       float64          `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Failures   []*junitFailure  `xml:"failure,omitempty"`
+	Errors     []*junitFailure  `xml:"error,omitempty"`
+	Skipped    *junitSkipped    `xml:"skipped,omitempty"`
+	SystemOut  *junitOutput     `xml:"system-out,omitempty"`
+
+	output strings.Builder
+}
+
+type junitProperties struct {
+	Properties []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type junitOutput struct {
+	Content string `xml:",cdata"`
+}
+
+func NewJUnitReporter(w io.Writer) *JUnitReporter {
+	return &JUnitReporter{
+		w:      w,
+		lock:   &sync.Mutex{},
+		suites: &[]*junitTestSuite{},
+	}
+}
+
+func (r *JUnitReporter) RunFile(ctx context.Context, filename string, fn func(reporter.Reporter)) {
+	start := time.Now()
+	suite := &junitTestSuite{
+		Name:      filename,
+		Timestamp: start.Format(time.RFC3339),
+	}
+
+	fn(&JUnitReporter{w: r.w, lock: r.lock, suites: r.suites, suite: suite})
+
+	suite.Time = time.Since(start).Seconds()
+	if suite.output.Len() > 0 {
+		suite.SystemOut = &junitOutput{Content: suite.output.String()}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	*r.suites = append(*r.suites, suite)
+}
+
+func (r *JUnitReporter) RunTest(ctx context.Context, runner, name string, fn func(reporter.Reporter)) {
+	start := time.Now()
+	test := &junitTestCase{
+		Name:      name,
+		Classname: r.suite.Name,
+	}
+	if runner != "" {
+		test.Properties = &junitProperties{
+			Properties: []junitProperty{{Name: "runner", Value: runner}},
+		}
+	}
+	r.suite.TestCases = append(r.suite.TestCases, test)
+
+	fn(&JUnitReporter{w: r.w, lock: r.lock, suites: r.suites, suite: r.suite, test: test})
+
+	test.Time = time.Since(start).Seconds()
+	if test.output.Len() > 0 {
+		test.SystemOut = &junitOutput{Content: test.output.String()}
+	}
+
+	r.suite.Tests++
+	switch {
+	case len(test.Errors) > 0:
+		r.suite.Errors++
+	case len(test.Failures) > 0:
+		r.suite.Failures++
+	case test.Skipped != nil:
+		r.suite.Skipped++
+	}
+}
+
+// ReportError adds a failure to the current test. Errors outside of a test,
+// such as syntax errors in the Lua file, are reported as an errored testcase
+// named after the file, as testsuites cannot contain errors directly.
+func (r *JUnitReporter) ReportError(err *reporter.Error) {
+	failure := &junitFailure{
+		Message: firstLine(err.Message),
+		Body:    err.Message,
+	}
+	if err.Timeout {
+		failure.Type = "timeout"
+	}
+
+	if r.test != nil {
+		r.test.Failures = append(r.test.Failures, failure)
+		return
+	}
+
+	if r.suite == nil {
+		return
+	}
+
+	failure.Type = "error"
+	r.suite.TestCases = append(r.suite.TestCases, &junitTestCase{
+		Name:      r.suite.Name,
+		Classname: r.suite.Name,
+		Errors:    []*junitFailure{failure},
+	})
+	r.suite.Tests++
+	r.suite.Errors++
+}
+
+func (r *JUnitReporter) Info(info reporter.Info) {
+	var out *strings.Builder
+	switch {
+	case r.test != nil:
+		out = &r.test.output
+	case r.suite != nil:
+		out = &r.suite.output
+	default:
+		return
+	}
+
+	fmt.Fprintf(out, "[%s] %s\n", info.Type, info.Title)
+	for _, arg := range info.Args {
+		if arg.Name != "" {
+			fmt.Fprintf(out, "  %s: %s\n", arg.Name, arg.Value)
+		} else {
+			fmt.Fprintf(out, "  %s\n", arg.Value)
+		}
+	}
+	if info.Content != "" {
+		out.WriteString(info.Content + "\n")
+	}
+	out.WriteString("\n")
+}
+
+func (r *JUnitReporter) Skip(reason string) {
+	r.test.Skipped = &junitSkipped{Message: reason}
+}
+
+func (r *JUnitReporter) Todo() {
+	r.test.Skipped = &junitSkipped{Message: "TODO"}
+}
+
+// Close writes the XML document for all files run so far.
+func (r *JUnitReporter) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	doc := junitTestSuites{Suites: *r.suites}
+	for _, s := range doc.Suites {
+		doc.Tests += s.Tests
+		doc.Failures += s.Failures
+		doc.Errors += s.Errors
+		doc.Skipped += s.Skipped
+		doc.Time += s.Time
+	}
+
+	if _, err := io.WriteString(r.w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(r.w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("encoding junit xml: %w", err)
+	}
+	_, err := io.WriteString(r.w, "\n")
+	return err
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
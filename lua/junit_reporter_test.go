@@ -0,0 +1,80 @@
+package lua
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/nais/tester/lua/reporter"
+)
+
+func TestJUnitReporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	r := NewJUnitReporter(buf)
+	ctx := context.Background()
+
+	r.RunFile(ctx, "users.lua", func(r reporter.Reporter) {
+		r.Info(reporter.Info{Type: reporter.InfoTypeHelper, Title: "Helper.SQLExec", Args: []reporter.InfoArg{{Name: "query", Value: "INSERT"}}})
+		r.RunTest(ctx, "gql", "list users", func(r reporter.Reporter) {
+			r.Info(reporter.Info{Type: reporter.InfoTypeQuery, Title: "GraphQL Query", Content: "{ users { id } }"})
+		})
+		r.RunTest(ctx, "gql", "get user", func(r reporter.Reporter) {
+			r.ReportError(reporter.NewDiffError("-a\n+b", "a", "b"))
+		})
+		r.RunTest(ctx, "sql", "skipped", func(r reporter.Reporter) {
+			r.Skip("not selected")
+		})
+	})
+	r.RunFile(ctx, "broken.lua", func(r reporter.Reporter) {
+		r.ReportError(reporter.NewError("syntax error"))
+	})
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := regexp.MustCompile(`(time|timestamp)="[^"]*"`).ReplaceAllString(buf.String(), `$1=""`)
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites tests="4" failures="1" errors="1" skipped="1" time="">
+  <testsuite name="users.lua" tests="3" failures="1" errors="0" skipped="1" time="" timestamp="">
+    <testcase name="list users" classname="users.lua" time="">
+      <properties>
+        <property name="runner" value="gql"></property>
+      </properties>
+      <system-out><![CDATA[[query] GraphQL Query
+{ users { id } }
+
+]]></system-out>
+    </testcase>
+    <testcase name="get user" classname="users.lua" time="">
+      <properties>
+        <property name="runner" value="gql"></property>
+      </properties>
+      <failure message="diff -want +got:">diff -want +got:&#xA;-a&#xA;+b</failure>
+    </testcase>
+    <testcase name="skipped" classname="users.lua" time="">
+      <properties>
+        <property name="runner" value="sql"></property>
+      </properties>
+      <skipped message="not selected"></skipped>
+    </testcase>
+    <system-out><![CDATA[[helper] Helper.SQLExec
+  query: INSERT
+
+]]></system-out>
+  </testsuite>
+  <testsuite name="broken.lua" tests="1" failures="0" errors="1" skipped="0" time="" timestamp="">
+    <testcase name="broken.lua" classname="broken.lua" time="">
+      <error message="syntax error" type="error">syntax error</error>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("JUnitReporter mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -1,15 +1,20 @@
 package lua
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/nais/tester/internal/webui"
 	"github.com/nais/tester/lua/reporter"
+	"github.com/nais/tester/lua/runner"
 	"github.com/nais/tester/lua/spec"
 	"golang.org/x/sync/errgroup"
 )
@@ -17,12 +22,20 @@ import (
 type SetupFunc func(ctx context.Context, dir string, config any) (retCtx context.Context, runners []spec.Runner, close func(), err error)
 
 type Manager struct {
-	runners       []spec.Runner
-	newConfigFn   func() any
-	setup         SetupFunc
-	dir           string
-	helpers       []*spec.Function
-	typeMetatable []*spec.Typemetatable
+	runners         []spec.Runner
+	newConfigFn     func() any
+	setup           SetupFunc
+	dir             string
+	helpers         []*spec.Function
+	matchers        []spec.Matcher
+	typeMetatable   []*spec.Typemetatable
+	maxParallel     int
+	filter          Filter
+	testTimeout     time.Duration
+	updateSnapshots bool
+	gqlCoverage     *runner.GQLCoverage
+	restCoverage    *runner.RestCoverage
+	cookieJar       bool
 }
 
 func New(newConfigFn func() any, setup SetupFunc, runners ...spec.Runner) (*Manager, error) {
@@ -51,9 +64,76 @@ func New(newConfigFn func() any, setup SetupFunc, runners ...spec.Runner) (*Mana
 	}, nil
 }
 
-func (m *Manager) Run(ctx context.Context, dir string, report reporter.Reporter) error {
+// Run runs all Lua files in dir. The returned error is non-nil if the
+// directory can't be read, or if any test failed, in which case it wraps
+// ErrTestsFailed. The result is returned in both cases, as long as the files
+// were run.
+func (m *Manager) Run(ctx context.Context, dir string, report reporter.Reporter) (*Result, error) {
 	m.dir = dir
-	return m.run(ctx, report)
+
+	start := time.Now()
+	results := newResultReporter(report)
+	if err := m.run(ctx, results); err != nil {
+		return nil, err
+	}
+	results.result.Duration = time.Since(start)
+	if m.gqlCoverage != nil {
+		results.result.GQLCoverage = m.gqlCoverage.Report()
+	}
+	if m.restCoverage != nil {
+		results.result.RestCoverage = m.restCoverage.Report()
+	}
+
+	return results.result, results.result.Err()
+}
+
+// SetMaxParallel sets the maximum number of files that are run concurrently.
+// Every file already gets its own Lua state and setup, so files are isolated
+// from each other as long as the SetupFunc creates independent resources.
+// Files containing a "-- tester:serial" comment before any code are run one at
+// a time after all parallel files are done. Values below 2 run every file in order.
+func (m *Manager) SetMaxParallel(n int) {
+	m.maxParallel = n
+}
+
+// SetFilter limits which files and tests are run. Tests that are filtered out
+// are reported as skipped, while filtered files are not reported at all.
+func (m *Manager) SetFilter(filter Filter) {
+	m.filter = filter
+}
+
+// SetTestTimeout sets the default maximum duration of a single test. Tests can
+// override it with the timeout option, e.g. Test.gql("name", { timeout = "5s" }, fn).
+// A zero duration disables the timeout.
+func (m *Manager) SetTestTimeout(d time.Duration) {
+	m.testTimeout = d
+}
+
+// SetUpdateSnapshots makes MatchSnapshot overwrite existing snapshots with
+// the actual values instead of comparing against them.
+func (m *Manager) SetUpdateSnapshots(update bool) {
+	m.updateSnapshots = update
+}
+
+// SetCookieJar enables a cookie jar for each file, shared by the REST and GQL
+// runners. Cookies set by responses are sent with later requests in the same
+// file, and the cookies in the jar are shown after each test.
+func (m *Manager) SetCookieJar(enabled bool) {
+	m.cookieJar = enabled
+}
+
+// SetGQLCoverage sets the coverage recorded by the GQL runners, which must be
+// created with runner.GQLRecordCoverage. The report is added to the result of
+// Run, and shown in the UI.
+func (m *Manager) SetGQLCoverage(coverage *runner.GQLCoverage) {
+	m.gqlCoverage = coverage
+}
+
+// SetRestCoverage sets the OpenAPI operation coverage recorded by the REST
+// runners, which must be created with runner.RestRecordCoverage. The report
+// is added to the result of Run.
+func (m *Manager) SetRestCoverage(coverage *runner.RestCoverage) {
+	m.restCoverage = coverage
 }
 
 func (m *Manager) run(ctx context.Context, report reporter.Reporter) error {
@@ -65,7 +145,17 @@ func (m *Manager) run(ctx context.Context, report reporter.Reporter) error {
 		case d.IsDir() || d.Name() == specFilename:
 			return nil
 		case filepath.Ext(d.Name()) == ".lua":
-			entries = append(entries, path)
+			rel, err := filepath.Rel(m.dir, path)
+			if err != nil {
+				return err
+			}
+			ok, err := m.filter.matchFile(rel)
+			if err != nil {
+				return err
+			}
+			if ok {
+				entries = append(entries, path)
+			}
 		}
 		return nil
 	})
@@ -73,16 +163,76 @@ func (m *Manager) run(ctx context.Context, report reporter.Reporter) error {
 		return fmt.Errorf("reading fs directory: %w", err)
 	}
 
+	if m.maxParallel < 2 {
+		for _, f := range entries {
+			m.runFile(ctx, f, report, m.updateSnapshots)
+		}
+		return nil
+	}
+
+	var serial []string
+	wg := &errgroup.Group{}
+	wg.SetLimit(m.maxParallel)
 	for _, f := range entries {
-		report.RunFile(ctx, f, func(r reporter.Reporter) {
-			s := newSuite(m, r)
-			s.run(ctx, f)
+		isSerial, err := hasSerialDirective(f)
+		if err != nil {
+			return fmt.Errorf("reading file %s: %w", f, err)
+		}
+
+		if isSerial {
+			serial = append(serial, f)
+			continue
+		}
+
+		wg.Go(func() error {
+			m.runFile(ctx, f, report, m.updateSnapshots)
+			return nil
 		})
 	}
+	_ = wg.Wait()
+
+	for _, f := range serial {
+		m.runFile(ctx, f, report, m.updateSnapshots)
+	}
 
 	return nil
 }
 
+func (m *Manager) runFile(ctx context.Context, filename string, report reporter.Reporter, updateSnapshots bool) {
+	report.RunFile(ctx, filename, func(r reporter.Reporter) {
+		s := newSuite(m, r)
+		s.updateSnapshots = updateSnapshots
+		s.run(ctx, filename)
+	})
+}
+
+const serialDirective = "tester:serial"
+
+// hasSerialDirective reports whether the leading comment block of the file
+// contains the serial directive.
+func hasSerialDirective(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		if strings.TrimSpace(strings.TrimLeft(line, "-")) == serialDirective {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
 func (m *Manager) RunUI(ctx context.Context, dir string) error {
 	m.dir = dir
 
@@ -110,7 +260,13 @@ func (m *Manager) RunUI(ctx context.Context, dir string) error {
 	})
 
 	wg.Go(func() error {
-		err := webui.Run(ctx, reporter)
+		var opts []webui.Option
+		if m.gqlCoverage != nil {
+			opts = append(opts, webui.WithCoverage(func() any {
+				return m.gqlCoverage.Report()
+			}))
+		}
+		err := webui.Run(ctx, reporter, opts...)
 		if err != nil {
 			fmt.Println("WEBUI ERROR", err)
 		}
@@ -125,10 +281,7 @@ func (m *Manager) handleReruns(ctx context.Context, sseReporter *webui.SSEReport
 		case <-ctx.Done():
 			return nil
 		case req := <-sseReporter.RerunChannel():
-			sseReporter.RunFile(ctx, req.Filename, func(r reporter.Reporter) {
-				s := newSuite(m, r)
-				s.run(ctx, req.Filename)
-			})
+			m.runFile(ctx, req.Filename, sseReporter, m.updateSnapshots || req.UpdateSnapshots)
 		}
 	}
 }
@@ -160,14 +313,12 @@ func (m *Manager) watch(ctx context.Context, dir string, report reporter.Reporte
 			}
 
 			if event.Op.Has(fsnotify.Write) {
-				if filepath.Base(event.Name) == specFilename {
+				// Only Lua files are run, snapshot files are written by the tests themselves
+				if filepath.Base(event.Name) == specFilename || filepath.Ext(event.Name) != ".lua" {
 					continue
 				}
 
-				report.RunFile(ctx, event.Name, func(r reporter.Reporter) {
-					s := newSuite(m, r)
-					s.run(ctx, event.Name)
-				})
+				m.runFile(ctx, event.Name, report, m.updateSnapshots)
 			} else if event.Op.Has(fsnotify.Remove) {
 				if sse, ok := report.(*webui.SSEReporter); ok {
 					sse.RemoveFile(event.Name)
@@ -179,14 +330,17 @@ func (m *Manager) watch(ctx context.Context, dir string, report reporter.Reporte
 }
 
 func (m *Manager) GenerateSpec(dir string) error {
-	path := filepath.Join(dir, specFilename)
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
-	if err != nil {
-		return fmt.Errorf("unable to open file %s: %w", path, err)
+	// The spec is generated before the file is opened, so an existing spec is
+	// kept if generating fails
+	buf := &bytes.Buffer{}
+	if err := GenerateSpec(buf, m.runners, m.newConfigFn(), m.helpers, m.typeMetatable, m.matchers); err != nil {
+		return fmt.Errorf("unable to generate spec: %w", err)
 	}
-	defer f.Close()
 
-	GenerateSpec(f, m.runners, m.newConfigFn(), m.helpers, m.typeMetatable)
+	path := filepath.Join(dir, specFilename)
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("unable to write file %s: %w", path, err)
+	}
 	return nil
 }
 
@@ -198,6 +352,12 @@ func (m *Manager) AddHelper(helper *spec.Function) {
 	m.helpers = append(m.helpers, helper)
 }
 
+// AddMatcher makes the matcher available in Lua as a global function named
+// after the matcher.
+func (m *Manager) AddMatcher(matcher spec.Matcher) {
+	m.matchers = append(m.matchers, matcher)
+}
+
 func (m *Manager) AddTypemetatable(tmt *spec.Typemetatable) {
 	m.typeMetatable = append(m.typeMetatable, tmt)
 }
@@ -0,0 +1,848 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/nais/tester/lua/reporter"
+	"github.com/nais/tester/lua/runner"
+	"github.com/nais/tester/lua/spec"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// concurrencyRunner tracks how many tests are running at the same time.
+type concurrencyRunner struct {
+	running atomic.Int32
+	max     atomic.Int32
+	serial  atomic.Bool
+}
+
+func (r *concurrencyRunner) Name() string {
+	return "conc"
+}
+
+func (r *concurrencyRunner) Functions() []*spec.Function {
+	return []*spec.Function{
+		{
+			Name: "wait",
+			Func: func(L *lua.LState) int {
+				n := r.running.Add(1)
+				defer r.running.Add(-1)
+				for {
+					m := r.max.Load()
+					if n <= m || r.max.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(50 * time.Millisecond)
+				return 0
+			},
+		},
+		{
+			Name: "serial",
+			Func: func(L *lua.LState) int {
+				if r.running.Load() != 0 {
+					L.RaiseError("serial file run concurrently with other files")
+				}
+				r.serial.Store(true)
+				return 0
+			},
+		},
+	}
+}
+
+// recordingReporter records file and test events and errors
+type recordingReporter struct {
+	lock   *sync.Mutex
+	events *[]string
+	prefix string
+}
+
+func newRecordingReporter() *recordingReporter {
+	return &recordingReporter{lock: &sync.Mutex{}, events: &[]string{}}
+}
+
+func (r *recordingReporter) add(event string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	*r.events = append(*r.events, r.prefix+event)
+}
+
+func (r *recordingReporter) RunFile(ctx context.Context, filename string, fn func(reporter.Reporter)) {
+	name := filepath.Base(filename)
+	r.add("file " + name)
+	fn(&recordingReporter{lock: r.lock, events: r.events, prefix: name + ": "})
+}
+
+func (r *recordingReporter) RunTest(ctx context.Context, runner, name string, fn func(reporter.Reporter)) {
+	r.add("test " + runner + " " + name)
+	fn(&recordingReporter{lock: r.lock, events: r.events, prefix: r.prefix + name + ": "})
+}
+
+func (r *recordingReporter) ReportError(err *reporter.Error) {
+	r.add("error " + err.Message)
+}
+
+func (r *recordingReporter) Info(info reporter.Info) {
+	if info.Type == reporter.InfoTypeCookies {
+		r.add("cookies " + info.Content)
+	}
+}
+
+func (r *recordingReporter) Skip(reason string) {
+	r.add("skip " + reason)
+}
+
+func (r *recordingReporter) Todo() {
+	r.add("todo")
+}
+
+func (r *recordingReporter) errors() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var ret []string
+	for _, e := range *r.events {
+		if strings.Contains(e, "error ") {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func writeLuaFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func newTestManager(t *testing.T, runners ...spec.Runner) *Manager {
+	t.Helper()
+	mgr, err := New(func() any { return &config{} }, func(ctx context.Context, dir string, config any) (context.Context, []spec.Runner, func(), error) {
+		return ctx, runners, nil, nil
+	}, runners...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mgr
+}
+
+func TestManagerRunParallel(t *testing.T) {
+	files := map[string]string{
+		"serial.lua": "-- tester:serial\nTest.conc(\"serial\", function(t) t.serial() end)\n",
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		files[name+".lua"] = "Test.conc(\"" + name + "\", function(t) t.wait() end)\n"
+	}
+	dir := writeLuaFiles(t, files)
+
+	r := &concurrencyRunner{}
+	mgr := newTestManager(t, r)
+	mgr.SetMaxParallel(4)
+
+	report := newRecordingReporter()
+	if _, err := mgr.Run(context.Background(), dir, report); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := report.errors(); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	if got := r.max.Load(); got < 2 {
+		t.Errorf("expected files to run concurrently, max concurrency was %d", got)
+	}
+
+	if !r.serial.Load() {
+		t.Errorf("expected serial file to run")
+	}
+}
+
+// nopRunner is a runner with a single function that does nothing
+type nopRunner struct {
+	name string
+}
+
+func (r *nopRunner) Name() string {
+	return r.name
+}
+
+func (r *nopRunner) Functions() []*spec.Function {
+	return []*spec.Function{
+		{Name: "nop", Func: func(L *lua.LState) int { return 0 }},
+	}
+}
+
+func TestManagerRunFilter(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"users.lua": `
+Test.gql("list users", function(t) t.nop() end)
+Test.gql("get user", function(t) t.nop() end)
+Test.sql("list users in db", function(t) t.nop() end)
+`,
+		"teams.lua": `Test.gql("list teams", function(t) t.nop() end)`,
+	})
+
+	mgr := newTestManager(t, &nopRunner{name: "gql"}, &nopRunner{name: "sql"})
+	mgr.SetFilter(Filter{
+		Files:   []string{"user*.lua"},
+		Name:    regexp.MustCompile("^list"),
+		Runners: []string{"gql"},
+	})
+
+	report := newRecordingReporter()
+	if _, err := mgr.Run(context.Background(), dir, report); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"file users.lua",
+		"users.lua: test gql list users",
+		"users.lua: test gql get user",
+		`users.lua: get user: skip name does not match "^list"`,
+		"users.lua: test sql list users in db",
+		`users.lua: list users in db: skip runner "sql" not selected`,
+	}
+	if diff := cmp.Diff(expected, *report.events); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManagerRunModifiers(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"only.lua": `
+Test.gql("before", function(t) t.nop() end)
+Test.only.gql("focused", function(t) t.nop() end)
+Test.skip.gql("skipped", function(t) error("should not run") end)
+Test.gql("after", function(t) t.nop() end)
+`,
+		"modifiers.lua": `
+-- Test.only.gql in comments and strings doesn't focus tests
+--[[ Test.only.gql("x", ...) ]]
+local _ = "Test.only.gql"
+Test.skip.gql("skipped", function(t) error("should not run") end)
+Test.todo("not implemented")
+Test.gql("run", function(t) t.nop() end)
+`,
+	})
+
+	mgr := newTestManager(t, &nopRunner{name: "gql"})
+
+	report := newRecordingReporter()
+	if _, err := mgr.Run(context.Background(), dir, report); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"file modifiers.lua",
+		"modifiers.lua: test gql skipped",
+		"modifiers.lua: skipped: skip skipped",
+		"modifiers.lua: test  not implemented",
+		"modifiers.lua: not implemented: todo",
+		"modifiers.lua: test gql run",
+		"file only.lua",
+		"only.lua: test gql before",
+		"only.lua: before: skip not marked with only",
+		"only.lua: test gql focused",
+		"only.lua: test gql skipped",
+		"only.lua: skipped: skip skipped",
+		"only.lua: test gql after",
+		"only.lua: after: skip not marked with only",
+	}
+	if diff := cmp.Diff(expected, *report.events); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManagerRunResult(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"a.lua": `
+Test.gql("passes", function(t) t.nop() end)
+Test.gql("fails", function(t) error("failure") end)
+Test.skip.gql("skipped", function(t) t.nop() end)
+Test.todo("todo")
+`,
+		"b.lua": `this is not lua`,
+	})
+
+	mgr := newTestManager(t, &nopRunner{name: "gql"})
+
+	res, err := mgr.Run(context.Background(), dir, newRecordingReporter())
+	if !errors.Is(err, ErrTestsFailed) {
+		t.Fatalf("expected ErrTestsFailed, got %v", err)
+	}
+
+	if res.Tests != 4 || res.Passed != 1 || res.Failed != 1 || res.Skipped != 2 || res.Errors != 1 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	var failures []string
+	for _, f := range res.Failures {
+		failures = append(failures, f.String())
+	}
+	slices.Sort(failures)
+
+	expected := []string{filepath.Join(dir, "a.lua") + ": fails", filepath.Join(dir, "b.lua")}
+	if diff := cmp.Diff(expected, failures); diff != "" {
+		t.Errorf("failures mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// blockingRunner has a function that blocks until the context is done
+type blockingRunner struct{}
+
+func (r *blockingRunner) Name() string {
+	return "block"
+}
+
+func (r *blockingRunner) Functions() []*spec.Function {
+	return []*spec.Function{
+		{
+			Name: "wait",
+			Func: func(L *lua.LState) int {
+				select {
+				case <-L.Context().Done():
+					L.RaiseError("%v", L.Context().Err())
+				case <-time.After(5 * time.Second):
+				}
+				return 0
+			},
+		},
+	}
+}
+
+func TestManagerRunTimeout(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"timeout.lua": `
+Test.block("blocking go function", function(t) t.wait() end)
+Test.block("infinite loop", { timeout = 0.05 }, function(t) while true do end end)
+Test.block("fast", { timeout = "1s" }, function(t) end)
+`,
+	})
+
+	mgr := newTestManager(t, &blockingRunner{})
+	mgr.SetTestTimeout(50 * time.Millisecond)
+
+	report := newRecordingReporter()
+	res, _ := mgr.Run(context.Background(), dir, report)
+	if res.Failed != 2 || res.Passed != 1 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	errs := report.errors()
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	for _, e := range errs {
+		if !strings.Contains(e, "test timed out after") {
+			t.Errorf("expected timeout error, got %q", e)
+		}
+	}
+}
+
+// flakyRunner has a function that fails until it has been called a number of times
+type flakyRunner struct {
+	calls atomic.Int32
+}
+
+func (r *flakyRunner) Name() string {
+	return "flaky"
+}
+
+func (r *flakyRunner) Functions() []*spec.Function {
+	return []*spec.Function{
+		{
+			Name: "failUntil",
+			Func: func(L *lua.LState) int {
+				n := L.CheckInt(1)
+				if c := r.calls.Add(1); int(c) < n {
+					L.RaiseError("call %d of %d", c, n)
+				}
+				return 0
+			},
+		},
+		{
+			Name: "reset",
+			Func: func(L *lua.LState) int {
+				r.calls.Store(0)
+				return 0
+			},
+		},
+	}
+}
+
+func TestManagerRunRetry(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"retry.lua": `
+Test.flaky("retry passes", { retry = 2 }, function(t)
+	t.failUntil(3)
+end)
+
+Test.flaky("eventually passes", function(t)
+	t.reset()
+	Eventually(function() t.failUntil(3) end, { interval = 0.001 })
+end)
+
+Test.flaky("eventually times out", function(t)
+	t.reset()
+	Eventually(function() t.failUntil(1000) end, { timeout = "20ms", interval = "1ms" })
+end)
+
+Test.flaky("retry fails", { retry = 1 }, function(t)
+	t.failUntil(1000)
+end)
+`,
+	})
+
+	mgr := newTestManager(t, &flakyRunner{})
+
+	report := newRecordingReporter()
+	res, _ := mgr.Run(context.Background(), dir, report)
+	if res.Passed != 2 || res.Failed != 2 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	var failed []string
+	for _, f := range res.Failures {
+		failed = append(failed, f.Name)
+	}
+	if diff := cmp.Diff([]string{"eventually times out", "retry fails"}, failed); diff != "" {
+		t.Errorf("failures mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManagerRunInvalidRetry(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"fraction.lua": `Test.flaky("fraction", { retry = 1.5 }, function(t) end)`,
+		"negative.lua": `Test.flaky("negative", { retry = -1 }, function(t) end)`,
+		"string.lua":   `Test.flaky("string", { retry = "2" }, function(t) end)`,
+		"zero.lua":     `Test.flaky("zero", { retry = 0 }, function(t) end)`,
+	})
+
+	mgr := newTestManager(t, &flakyRunner{})
+
+	report := newRecordingReporter()
+	res, _ := mgr.Run(context.Background(), dir, report)
+	if res.Passed != 1 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	errs := report.errors()
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", errs)
+	}
+	for _, e := range errs {
+		if !strings.Contains(e, "retry must be a non-negative integer") {
+			t.Errorf("expected retry error, got %q", e)
+		}
+	}
+}
+
+// logRunner records the messages logged by the tests
+type logRunner struct {
+	lock sync.Mutex
+	logs []string
+}
+
+func (r *logRunner) Name() string {
+	return "log"
+}
+
+func (r *logRunner) Functions() []*spec.Function {
+	return []*spec.Function{
+		{
+			Name: "log",
+			Func: func(L *lua.LState) int {
+				r.lock.Lock()
+				defer r.lock.Unlock()
+				r.logs = append(r.logs, L.CheckString(1))
+				return 0
+			},
+		},
+	}
+}
+
+func TestManagerRunAfterEachAfterTimeout(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"timeout.lua": `
+AfterEach(function(t) t.log("after each") end)
+
+Test.log("infinite loop", { timeout = 0.05, retry = 1 }, function(t)
+	t.log("attempt")
+	while true do end
+end)
+`,
+	})
+
+	r := &logRunner{}
+	mgr := newTestManager(t, r)
+
+	report := newRecordingReporter()
+	if _, err := mgr.Run(context.Background(), dir, report); !errors.Is(err, ErrTestsFailed) {
+		t.Fatalf("expected ErrTestsFailed, got %v", err)
+	}
+
+	expected := []string{"attempt", "after each", "attempt", "after each"}
+	if diff := cmp.Diff(expected, r.logs); diff != "" {
+		t.Errorf("logs mismatch (-want +got):\n%s", diff)
+	}
+
+	errs := report.errors()
+	if len(errs) != 1 || !strings.Contains(errs[0], "test timed out after") {
+		t.Errorf("expected a timeout error, got %v", errs)
+	}
+}
+
+func TestManagerRunHooks(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"hooks.lua": `
+BeforeAll(function() Helper.log("before all") end)
+BeforeEach(function(t) t.log("before each") end)
+AfterEach(function(t) t.log("after each") end)
+AfterAll(function() Helper.log("after all") end)
+
+Test.log("first", function(t) t.log("first") end)
+Test.log("second", function(t) error("failure") end)
+`,
+		"failing_hook.lua": `
+BeforeEach(function(t) error("hook error") end)
+Test.log("test", function(t) t.log("should not run") end)
+`,
+		"failing_before_all.lua": `
+BeforeAll(function() error("before all error") end)
+Test.log("test", function(t) t.log("should not run") end)
+`,
+	})
+
+	r := &logRunner{}
+	mgr := newTestManager(t, r)
+	mgr.AddHelper(&spec.Function{Name: "log", Func: r.Functions()[0].Func})
+	mgr.SetFilter(Filter{Files: []string{"hooks.lua"}})
+
+	if _, err := mgr.Run(context.Background(), dir, newRecordingReporter()); !errors.Is(err, ErrTestsFailed) {
+		t.Fatalf("expected ErrTestsFailed, got %v", err)
+	}
+
+	expected := []string{
+		"before all",
+		"before each", "first", "after each",
+		"before each", "after each",
+		"after all",
+	}
+	if diff := cmp.Diff(expected, r.logs); diff != "" {
+		t.Errorf("logs mismatch (-want +got):\n%s", diff)
+	}
+
+	mgr.SetFilter(Filter{Files: []string{"failing_*.lua"}})
+	report := newRecordingReporter()
+	if _, err := mgr.Run(context.Background(), dir, report); !errors.Is(err, ErrTestsFailed) {
+		t.Fatalf("expected ErrTestsFailed, got %v", err)
+	}
+
+	var events []string
+	for _, e := range *report.events {
+		events = append(events, strings.Split(e, "\n")[0])
+	}
+	expectedEvents := []string{
+		"file failing_before_all.lua",
+		"failing_before_all.lua: error BeforeAll hook failed: " + filepath.Join(dir, "failing_before_all.lua") + ":2: before all error",
+		"failing_before_all.lua: test log test",
+		"failing_before_all.lua: test: skip BeforeAll hook failed",
+		"file failing_hook.lua",
+		"failing_hook.lua: test log test",
+		"failing_hook.lua: test: error BeforeEach hook failed: " + filepath.Join(dir, "failing_hook.lua") + ":2: hook error",
+	}
+	if diff := cmp.Diff(expectedEvents, events); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// responseRunner checks against a response set by the test
+type responseRunner struct {
+	response any
+}
+
+func (r *responseRunner) Name() string {
+	return "resp"
+}
+
+func (r *responseRunner) Functions() []*spec.Function {
+	return []*spec.Function{
+		runner.StdCheckDefinition(func(L *lua.LState) int {
+			runner.StdCheck(L, runner.CheckExpected(L, 1), r.response)
+			return 0
+		}),
+	}
+}
+
+func TestManagerRunSnapshots(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"users.lua": `
+Test.resp("users", function(t)
+	t.check({
+		data = MatchSnapshot("users", { { id = Ignore() } }),
+	})
+end)
+`,
+	})
+	snapshotFile := filepath.Join(dir, "users.snapshots.json")
+
+	r := &responseRunner{}
+	mgr := newTestManager(t, r)
+	run := func(users ...any) error {
+		t.Helper()
+		r.response = map[string]any{"data": users}
+		_, err := mgr.Run(context.Background(), dir, newRecordingReporter())
+		return err
+	}
+
+	if err := run(map[string]any{"id": 1.0, "name": "a"}, map[string]any{"id": 2.0, "name": "b"}); err != nil {
+		t.Fatalf("first run should write the snapshot, got %v", err)
+	}
+	b, err := os.ReadFile(snapshotFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "users": [
+    {
+      "id": 1,
+      "name": "a"
+    },
+    {
+      "id": 2,
+      "name": "b"
+    }
+  ]
+}
+`
+	if diff := cmp.Diff(expected, string(b)); diff != "" {
+		t.Errorf("snapshot mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := run(map[string]any{"id": 3.0, "name": "a"}, map[string]any{"id": 4.0, "name": "b"}); err != nil {
+		t.Errorf("ignored fields should not fail, got %v", err)
+	}
+
+	if err := run(map[string]any{"id": 1.0, "name": "a"}, map[string]any{"id": 2.0, "name": "c"}); !errors.Is(err, ErrTestsFailed) {
+		t.Errorf("expected ErrTestsFailed, got %v", err)
+	}
+
+	mgr.SetUpdateSnapshots(true)
+	if err := run(map[string]any{"id": 1.0, "name": "c"}); err != nil {
+		t.Errorf("updating should not fail, got %v", err)
+	}
+	mgr.SetUpdateSnapshots(false)
+	if err := run(map[string]any{"id": 5.0, "name": "c"}); err != nil {
+		t.Errorf("expected updated snapshot to match, got %v", err)
+	}
+}
+
+func TestManagerRunCustomMatchers(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"matchers.lua": `
+Test.resp("custom matcher", function(t)
+	t.check({ id = HasPrefix("user-"), count = Satisfies(function(v) return v > 3 end) })
+end)
+
+Test.resp("failing predicate", function(t)
+	t.check({ id = Ignore(), count = Satisfies(function(v) return v < 3 end) })
+end)
+
+Test.resp("failing matcher", function(t)
+	t.check({ id = HasPrefix("team-"), count = Ignore() })
+end)
+
+Test.resp("predicate error", function(t)
+	t.check({ id = Ignore(), count = Satisfies(function(v) error("predicate failed") end) })
+end)
+`,
+	})
+
+	r := &responseRunner{response: map[string]any{"id": "user-1", "count": 4.0}}
+	mgr := newTestManager(t, r)
+	mgr.AddMatcher(&prefixMatcher{})
+
+	result, err := mgr.Run(context.Background(), dir, newRecordingReporter())
+	if !errors.Is(err, ErrTestsFailed) {
+		t.Fatalf("expected ErrTestsFailed, got %v", err)
+	}
+
+	var failures []string
+	for _, f := range result.Failures {
+		failures = append(failures, f.Name+": "+strings.Split(f.Message, "\n")[0])
+	}
+	expected := []string{
+		"failing predicate: diff -want +got:",
+		"failing matcher: diff -want +got:",
+		"predicate error: " + filepath.Join(dir, "matchers.lua") + ":15: predicate failed",
+	}
+	if diff := cmp.Diff(expected, failures); diff != "" {
+		t.Errorf("failures mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(result.Failures[1].Message, `[[[ HasPrefix("team-") ]]]`) {
+		t.Errorf("expected matcher in diff, got:\n%s", result.Failures[1].Message)
+	}
+}
+
+func TestManagerRunSave(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"save.lua": `
+Test.resp("save", function(t)
+	t.check({
+		{ id = Save("firstID"), team = Save("team"), tags = Save("tags") },
+		{ id = Save("secondID"), team = Ignore(), tags = Ignore() },
+	})
+end)
+
+Test.resp("use saved", function(t)
+	assert(State.firstID == 1, "firstID")
+	assert(State.secondID == 2, "secondID")
+	assert(State.team.slug == "a", "team.slug")
+	assert(State.team.members[2].name == "y", "team.members")
+	assert(#State.tags == 2 and State.tags[2] == "b", "tags")
+end)
+
+Test.resp("unresolved path", function(t)
+	t.check({
+		{ missing = Save("missing", true), id = Ignore(), team = Ignore(), tags = Ignore() },
+		{ id = Ignore(), team = Ignore(), tags = Ignore() },
+	})
+end)
+`,
+	})
+
+	r := &responseRunner{response: []any{
+		map[string]any{
+			"id": int32(1),
+			"team": map[string]any{
+				"slug":    "a",
+				"members": []any{map[string]any{"name": "x"}, map[string]any{"name": "y"}},
+			},
+			"tags": []any{"a", "b"},
+		},
+		map[string]any{"id": int64(2), "team": nil, "tags": []any{}},
+	}}
+	mgr := newTestManager(t, r)
+
+	result, err := mgr.Run(context.Background(), dir, newRecordingReporter())
+	if !errors.Is(err, ErrTestsFailed) {
+		t.Fatalf("expected ErrTestsFailed, got %v", err)
+	}
+
+	if len(result.Failures) != 1 || result.Failures[0].Name != "unresolved path" {
+		t.Fatalf("expected only unresolved path to fail, got %+v", result.Failures)
+	}
+	expected := `unable to save "missing": unable to resolve path ".0.missing": key "missing" not found`
+	if !strings.Contains(result.Failures[0].Message, expected) {
+		t.Errorf("expected message to contain %q, got:\n%s", expected, result.Failures[0].Message)
+	}
+}
+
+func TestManagerRunCookieJar(t *testing.T) {
+	dir := writeLuaFiles(t, map[string]string{
+		"a.lua": `
+Test.rest("login", function(t)
+	assert(t.cookie("session") == nil, "no session before login")
+	t.send("POST", "/login", nil, { form = { user = "a" } })
+	assert(t.cookie("session") == "a", "session after login")
+end)
+
+Test.gql("query with session", function(t)
+	local res = t.query("{ me }")
+	assert(res.data.me == "a", "expected session cookie to be sent, got " .. tostring(res.data.me))
+	t.setCookie("theme", "dark", { path = "/" })
+	assert(#t.cookies() == 2, "expected two cookies")
+end)
+
+Test.rest("clear", function(t)
+	t.clearCookies()
+	local res = t.send("GET", "/me")
+	assert(res.body == nil, "expected no cookies after clear")
+end)
+`,
+		"b.lua": `
+Test.rest("isolated", function(t)
+	assert(#t.cookies() == 0, "expected no cookies from other files")
+end)
+`,
+	})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/login":
+			_ = req.ParseForm()
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: req.PostForm.Get("user"), Path: "/", HttpOnly: true})
+		case "/me":
+			w.Header().Set("Content-Type", "text/plain")
+			if c, err := req.Cookie("session"); err == nil {
+				_, _ = io.WriteString(w, c.Value)
+			}
+		default:
+			me := ""
+			if c, err := req.Cookie("session"); err == nil {
+				me = c.Value
+			}
+			_, _ = fmt.Fprintf(w, `{"data": {"me": %q}}`, me)
+		}
+	})
+
+	mgr := newTestManager(t, runner.NewRestRunner(handler), runner.NewGQLRunner(handler))
+	mgr.SetCookieJar(true)
+
+	report := newRecordingReporter()
+	if _, err := mgr.Run(context.Background(), dir, report); err != nil {
+		t.Fatalf("unexpected error: %v\n%v", err, report.errors())
+	}
+
+	var cookies []string
+	for _, e := range *report.events {
+		if strings.Contains(e, "cookies ") {
+			cookies = append(cookies, e)
+		}
+	}
+	expected := []string{
+		"a.lua: login: cookies session=a; Domain=localhost; Path=/; HttpOnly",
+		"a.lua: query with session: cookies session=a; Domain=localhost; Path=/; HttpOnly\ntheme=dark; Domain=localhost; Path=/",
+	}
+	if diff := cmp.Diff(expected, cookies); diff != "" {
+		t.Errorf("cookies mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHasSerialDirective(t *testing.T) {
+	tests := map[string]bool{
+		"-- tester:serial\nTest.gql()":                    true,
+		"\n-- some comment\n---   tester:serial\n":        true,
+		"Test.gql()\n-- tester:serial\n":                  false,
+		"-- tester:serial is not a directive\nTest.gql()": false,
+	}
+
+	for content, expected := range tests {
+		dir := writeLuaFiles(t, map[string]string{"file.lua": content})
+		got, err := hasSerialDirective(filepath.Join(dir, "file.lua"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Errorf("hasSerialDirective(%q) = %v, want %v", content, got, expected)
+		}
+	}
+}
@@ -17,6 +17,8 @@ type Error struct {
 	Message  string `json:"message"`
 	Expected any    `json:"expected,omitempty"`
 	Actual   any    `json:"actual,omitempty"`
+	// Timeout is true if the test was aborted because it ran for too long
+	Timeout bool `json:"timeout,omitempty"`
 }
 
 func (e *Error) Error() string {
@@ -39,6 +41,14 @@ func NewDiffError(diff string, expected, actual any) *Error {
 	}
 }
 
+// NewTimeoutError creates an error for a test that exceeded its timeout
+func NewTimeoutError(timeout, elapsed time.Duration) *Error {
+	return &Error{
+		Message: fmt.Sprintf("test timed out after %s (timeout %s)", elapsed.Round(time.Millisecond), timeout),
+		Timeout: true,
+	}
+}
+
 // InfoType represents the type of information being reported
 type InfoType string
 
@@ -53,6 +63,14 @@ const (
 	InfoTypeQuery InfoType = "query"
 	// InfoTypeResult is used for query results
 	InfoTypeResult InfoType = "result"
+	// InfoTypeRetry is used when a test or an Eventually block is retried
+	InfoTypeRetry InfoType = "retry"
+	// InfoTypeSnapshot is used when a snapshot is written
+	InfoTypeSnapshot InfoType = "snapshot"
+	// InfoTypeCookies is used for the state of the cookie jar after a test
+	InfoTypeCookies InfoType = "cookies"
+	// InfoTypeWarning is used for problems that don't fail the test
+	InfoTypeWarning InfoType = "warning"
 )
 
 // Info represents a piece of information about a test execution
@@ -70,4 +88,10 @@ type Reporter interface {
 	RunTest(ctx context.Context, runner, name string, fn func(Reporter))
 	ReportError(err *Error)
 	Info(info Info)
+	// Skip marks the current test as skipped. It is called from within RunTest
+	// and should be the last call made on the test reporter.
+	Skip(reason string)
+	// Todo marks the current test as not yet implemented. Like Skip, it is
+	// called from within RunTest as the last call on the test reporter.
+	Todo()
 }
@@ -0,0 +1,189 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nais/tester/lua/reporter"
+	"github.com/nais/tester/lua/runner"
+)
+
+// ErrTestsFailed is wrapped by the error returned from Manager.Run when one or
+// more tests or files failed.
+var ErrTestsFailed = errors.New("tests failed")
+
+// Result summarizes a call to Manager.Run.
+type Result struct {
+	Files   []*FileResult
+	Tests   int
+	Passed  int
+	Failed  int
+	Skipped int
+	// Errors counts errors that happened outside of a test
+	Errors   int
+	Duration time.Duration
+	// Failures lists every failed test. Errors that happen outside of a test,
+	// e.g. syntax errors, are listed with an empty test name.
+	Failures []TestID
+	// GQLCoverage is the schema coverage of the run, if set with
+	// Manager.SetGQLCoverage
+	GQLCoverage *runner.GQLCoverageReport
+	// RestCoverage is the OpenAPI operation coverage of the run, if set with
+	// Manager.SetRestCoverage
+	RestCoverage *runner.RestCoverageReport
+}
+
+// FileResult summarizes a single Lua file.
+type FileResult struct {
+	Name    string
+	Tests   int
+	Passed  int
+	Failed  int
+	Skipped int
+	// Errors counts errors that happened outside of a test
+	Errors   int
+	Duration time.Duration
+}
+
+// TestID identifies a single test.
+type TestID struct {
+	File    string
+	Runner  string
+	Name    string
+	Message string
+}
+
+func (t TestID) String() string {
+	if t.Name == "" {
+		return t.File
+	}
+	return fmt.Sprintf("%s: %s", t.File, t.Name)
+}
+
+// Err returns nil if all tests passed, and an error wrapping ErrTestsFailed
+// together with an error for each failure otherwise.
+func (r *Result) Err() error {
+	if len(r.Failures) == 0 {
+		return nil
+	}
+
+	summary := fmt.Errorf("%w: %d of %d tests failed", ErrTestsFailed, r.Failed, r.Tests)
+	if r.Errors > 0 {
+		summary = fmt.Errorf("%w, %d errors outside of tests", summary, r.Errors)
+	}
+
+	errs := []error{summary}
+	for _, f := range r.Failures {
+		errs = append(errs, fmt.Errorf("%s: %s", f, firstLine(f.Message)))
+	}
+	return errors.Join(errs...)
+}
+
+// resultReporter wraps a reporter and records the results into a Result.
+type resultReporter struct {
+	reporter.Reporter
+	lock   *sync.Mutex
+	result *Result
+
+	file   *FileResult
+	test   *TestID
+	failed bool
+	// skipped is set for skipped and todo tests
+	skipped bool
+}
+
+func newResultReporter(r reporter.Reporter) *resultReporter {
+	return &resultReporter{
+		Reporter: r,
+		lock:     &sync.Mutex{},
+		result:   &Result{},
+	}
+}
+
+func (r *resultReporter) RunFile(ctx context.Context, filename string, fn func(reporter.Reporter)) {
+	start := time.Now()
+	file := &FileResult{Name: filename}
+
+	r.Reporter.RunFile(ctx, filename, func(inner reporter.Reporter) {
+		fn(&resultReporter{Reporter: inner, lock: r.lock, result: r.result, file: file})
+	})
+
+	file.Duration = time.Since(start)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.result.Files = append(r.result.Files, file)
+	r.result.Tests += file.Tests
+	r.result.Passed += file.Passed
+	r.result.Failed += file.Failed
+	r.result.Skipped += file.Skipped
+	r.result.Errors += file.Errors
+}
+
+func (r *resultReporter) RunTest(ctx context.Context, runner, name string, fn func(reporter.Reporter)) {
+	test := &resultReporter{
+		lock:   r.lock,
+		result: r.result,
+		file:   r.file,
+		test:   &TestID{File: r.file.Name, Runner: runner, Name: name},
+	}
+
+	r.Reporter.RunTest(ctx, runner, name, func(inner reporter.Reporter) {
+		test.Reporter = inner
+		fn(test)
+	})
+
+	r.file.Tests++
+	switch {
+	case test.failed:
+		r.file.Failed++
+	case test.skipped:
+		r.file.Skipped++
+	default:
+		r.file.Passed++
+	}
+}
+
+func (r *resultReporter) ReportError(err *reporter.Error) {
+	id := TestID{Message: err.Message}
+	switch {
+	case r.test != nil:
+		if r.failed {
+			// Only record the first error of each test
+			break
+		}
+		r.failed = true
+		id.File, id.Runner, id.Name = r.test.File, r.test.Runner, r.test.Name
+		r.addFailure(id)
+	case r.file != nil:
+		id.File = r.file.Name
+		r.file.Errors++
+		r.addFailure(id)
+	default:
+		r.lock.Lock()
+		r.result.Errors++
+		r.lock.Unlock()
+		r.addFailure(id)
+	}
+
+	r.Reporter.ReportError(err)
+}
+
+func (r *resultReporter) addFailure(id TestID) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.result.Failures = append(r.result.Failures, id)
+}
+
+func (r *resultReporter) Skip(reason string) {
+	r.skipped = true
+	r.Reporter.Skip(reason)
+}
+
+func (r *resultReporter) Todo() {
+	r.skipped = true
+	r.Reporter.Todo()
+}
@@ -3,6 +3,9 @@ package runner
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -21,6 +24,9 @@ const (
 	ctxSaveFunc contextKey = iota
 	ctxReporter
 	ctxCheckError
+	ctxSnapshots
+	ctxFilename
+	ctxCookieJar
 )
 
 const (
@@ -41,6 +47,22 @@ func WithReporter(ctx context.Context, r reporter.Reporter) context.Context {
 	return context.WithValue(ctx, ctxReporter, r)
 }
 
+// WithFilename sets the name of the Lua file being run, used to resolve paths
+// relative to the file.
+func WithFilename(ctx context.Context, filename string) context.Context {
+	return context.WithValue(ctx, ctxFilename, filename)
+}
+
+// ResolvePath returns path relative to the directory of the Lua file being
+// run. Absolute paths are returned as is.
+func ResolvePath(ctx context.Context, path string) string {
+	filename, _ := ctx.Value(ctxFilename).(string)
+	if filepath.IsAbs(path) || filename == "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(filename), path)
+}
+
 func GetReporter(ctx context.Context) reporter.Reporter {
 	r, _ := ctx.Value(ctxReporter).(reporter.Reporter)
 	return r
@@ -86,8 +108,11 @@ func GetCheckError(ctx context.Context) (*CheckError, bool) {
 	return err, ok
 }
 
-func StdCheck(L *lua.LState, tbl *lua.LTable, b any) {
-	if err := StdCheckError(L.Context(), tbl, b); err != nil {
+// StdCheck compares the expected value against b, and raises an error with a
+// diff if they differ. Expected is usually a table, but can also be a matcher
+// such as MatchSnapshot.
+func StdCheck(L *lua.LState, expected lua.LValue, b any) {
+	if err := StdCheckError(L.Context(), expected, b); err != nil {
 		// Store structured error in context before raising
 		if checkErr, ok := err.(*CheckError); ok {
 			L.SetContext(SetCheckError(L.Context(), checkErr))
@@ -96,9 +121,14 @@ func StdCheck(L *lua.LState, tbl *lua.LTable, b any) {
 	}
 }
 
-func StdCheckError(ctx context.Context, tbl *lua.LTable, b any) error {
-	toSave := make(map[string]string)
-	a, opts := convertToCheck("", tbl, toSave, nil)
+func StdCheckError(ctx context.Context, expected lua.LValue, b any) error {
+	expected, _, err := resolveSnapshots(ctx, expected, b)
+	if err != nil {
+		return err
+	}
+
+	toSave := newSaves()
+	a, opts := convertToCheck("", expected, toSave, nil)
 
 	diff := cmp.Diff(a, b, opts...)
 	if diff != "" {
@@ -111,46 +141,60 @@ func StdCheckError(ctx context.Context, tbl *lua.LTable, b any) error {
 
 	saveFunc := ctx.Value(ctxSaveFunc).(SaveFunc)
 
-	for path, name := range toSave {
-		appendStore(name, path, b, saveFunc)
+	for path, name := range toSave.resolve("") {
+		val, err := valueAtPath(b, path)
+		if err != nil {
+			return fmt.Errorf("unable to save %q: %w", name, err)
+		}
+		saveFunc(name, val)
 	}
 	return nil
 }
 
-func appendStore(key, path string, body any, fn SaveFunc) {
-	if _, ok := body.(map[string]any); !ok {
-		return
+// valueAtPath returns the value at path in body. The path is on the form used
+// by convertToCheck, e.g. ".data.users.0.id", where list indexes are 0-based.
+func valueAtPath(body any, path string) (any, error) {
+	val := body
+	if path == "" {
+		return val, nil
 	}
 
-	var (
-		root = body.(map[string]any)
-		val  any
-	)
-
-	pathParts := strings.Split(path, ".")
-
-	for i := 0; i < len(pathParts); i++ {
-		if i == len(pathParts)-1 {
-			// Last element of pathParts
-			val = root[pathParts[i]]
-			break
-		} else if i < len(pathParts)-2 {
-			// check if next value is a list
-			intVal, err := strconv.Atoi(pathParts[i+1])
-			if err == nil {
-				slice := root[pathParts[i]].([]any)
-				root = slice[intVal].(map[string]any)
-				// skip one iteration on lists
-				i += 1
-				continue
+	for part := range strings.SplitSeq(strings.TrimPrefix(path, "."), ".") {
+		rv := reflect.ValueOf(val)
+		switch rv.Kind() {
+		case reflect.Map:
+			if rv.Type().Key().Kind() != reflect.String {
+				return nil, fmt.Errorf("unable to resolve path %q: %q is not in a map with string keys", path, part)
 			}
-		}
-		mp, ok := root[pathParts[i]].(map[string]any)
-		if ok {
-			root = mp
+			e := rv.MapIndex(reflect.ValueOf(part).Convert(rv.Type().Key()))
+			if !e.IsValid() {
+				return nil, fmt.Errorf("unable to resolve path %q: key %q not found", path, part)
+			}
+			val = e.Interface()
+		case reflect.Slice, reflect.Array:
+			idx, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve path %q: %q is not a list index", path, part)
+			}
+			if idx < 0 || idx >= rv.Len() {
+				return nil, fmt.Errorf("unable to resolve path %q: index %d out of range", path, idx)
+			}
+			val = rv.Index(idx).Interface()
+		default:
+			return nil, fmt.Errorf("unable to resolve path %q: %q is not in a map or list", path, part)
 		}
 	}
-	fn(key, val)
+	return val, nil
+}
+
+// CheckExpected returns argument n, which must be a table or a matcher, as the
+// expected value for StdCheck.
+func CheckExpected(L *lua.LState, n int) lua.LValue {
+	v := L.CheckAny(n)
+	if v.Type() != lua.LTTable && v.Type() != lua.LTUserData {
+		L.TypeError(n, lua.LTTable)
+	}
+	return v
 }
 
 func StdCheckDefinition(fn lua.LGFunction) *spec.Function {
@@ -159,7 +203,7 @@ func StdCheckDefinition(fn lua.LGFunction) *spec.Function {
 		Args: []spec.Argument{
 			{
 				Name: "resp",
-				Type: []spec.ArgumentType{spec.ArgumentTypeTable},
+				Type: []spec.ArgumentType{spec.ArgumentTypeTable, spec.ArgumentTypeUserData},
 				Doc:  "The response to check",
 			},
 		},
@@ -171,10 +215,10 @@ func StdCheckDefinition(fn lua.LGFunction) *spec.Function {
 // convertToCheck converts a lua table to a go map suitable for comparison
 // Path is the path to the current value in the table, should be empty on first call
 // v is the current value in the table
-// toSave is a map of paths to names of values that should be saved
+// toSave records the paths of values that should be saved
 // opts is a list of cmp options
 // It returns the converted value and the updated list of cmp options
-func convertToCheck(path string, v lua.LValue, toSave map[string]string, opts cmp.Options) (any, cmp.Options) {
+func convertToCheck(path string, v lua.LValue, toSave *saves, opts cmp.Options) (any, cmp.Options) {
 	switch v.Type() {
 	case lua.LTNil:
 		return nil, opts
@@ -223,7 +267,7 @@ func convertToCheck(path string, v lua.LValue, toSave map[string]string, opts cm
 
 		switch v := ud.Value.(type) {
 		case spec.SaveData:
-			toSave[path] = v.Name
+			toSave.paths[path] = v.Name
 			if v.AllowNull {
 				return "[[[ save_allow_null ]]]", append(opts, allowNull(path))
 			}
@@ -234,12 +278,67 @@ func convertToCheck(path string, v lua.LValue, toSave map[string]string, opts cm
 			return "[[[ not_null ]]]", append(opts, notNull(path))
 		case spec.Null:
 			return nil, opts
+		case spec.SnapshotData:
+			panic("snapshot " + strconv.Quote(v.Name) + " was not resolved")
 		case spec.ContainsString:
 			value := containsString
 			if v.CaseSensitive {
 				value = containsStringCaseSensitive
 			}
 			return value, append(opts, stringContains(path, v.Contains, v.CaseSensitive))
+		case spec.MatchesData:
+			return matcher(path, fmt.Sprintf("[[[ matches %q ]]]", v.Regexp.String()), opts, func(a any) bool {
+				s, ok := a.(string)
+				return ok && v.Regexp.MatchString(s)
+			})
+		case spec.BetweenData:
+			return matcher(path, fmt.Sprintf("[[[ between %v and %v ]]]", v.Min, v.Max), opts, func(a any) bool {
+				n, ok := toFloat(a)
+				return ok && n >= v.Min && n <= v.Max
+			})
+		case spec.GreaterThanData:
+			return matcher(path, fmt.Sprintf("[[[ greater than %v ]]]", v.Value), opts, func(a any) bool {
+				n, ok := toFloat(a)
+				return ok && n > v.Value
+			})
+		case spec.LessThanData:
+			return matcher(path, fmt.Sprintf("[[[ less than %v ]]]", v.Value), opts, func(a any) bool {
+				n, ok := toFloat(a)
+				return ok && n < v.Value
+			})
+		case spec.IsTypeData:
+			return matcher(path, fmt.Sprintf("[[[ type %s ]]]", v.Type), opts, func(a any) bool {
+				return isType(a, v.Type)
+			})
+		case spec.OneOfData:
+			return matcher(path, fmt.Sprintf("[[[ one of [%s] ]]]", formatValues(v.Values)), opts, func(a any) bool {
+				return slices.ContainsFunc(v.Values, func(e any) bool { return equalScalar(e, a) })
+			})
+		case spec.ISODateData:
+			return matcher(path, "[[[ iso date ]]]", opts, isISODate)
+		case spec.LengthData:
+			return matcher(path, fmt.Sprintf("[[[ length %d ]]]", v.Length), opts, func(a any) bool {
+				return hasLength(a, v.Length)
+			})
+		case spec.UnorderedData:
+			return listMatcher(path, convertElements(v.Elements), true, toSave, opts)
+		case spec.ContainsElementsData:
+			return listMatcher(path, convertElements(v.Elements), false, toSave, opts)
+		case spec.MatcherData:
+			return matcher(path, fmt.Sprintf("[[[ %s(%s) ]]]", v.Matcher.Name(), formatValues(v.Args)), opts, func(a any) bool {
+				return v.Matcher.Match(v.Args, a)
+			})
+		case spec.SatisfiesData:
+			return matcher(path, "[[[ satisfies ]]]", opts, func(a any) bool {
+				return satisfies(v.L, v.Fn, a)
+			})
+		case spec.PartialData:
+			m := make(map[string]any)
+			v.Fields.ForEach(func(k, val lua.LValue) {
+				key := lua.LVAsString(k)
+				m[key], opts = convertToCheck(path+"."+key, val, toSave, opts)
+			})
+			return m, append(opts, partial(path, m))
 		default:
 			panic("unknown userdata type " + fmt.Sprintf("%T", v))
 		}
@@ -0,0 +1,297 @@
+package runner
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/nais/tester/lua/spec"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// evalExpected evaluates a Lua expression with the matchers registered
+func evalExpected(t *testing.T, expr string) lua.LValue {
+	t.Helper()
+	L := lua.NewState()
+	t.Cleanup(L.Close)
+
+	L.Register("Matches", spec.Matches)
+	L.Register("Between", spec.Between)
+	L.Register("GreaterThan", spec.GreaterThan)
+	L.Register("LessThan", spec.LessThan)
+	L.Register("IsType", spec.IsType)
+	L.Register("OneOf", spec.OneOf)
+	L.Register("ISODate", spec.ISODate)
+	L.Register("Unordered", spec.Unordered)
+	L.Register("ContainsElements", spec.ContainsElements)
+	L.Register("Partial", spec.Partial)
+	L.Register("Length", spec.Length)
+	L.Register("Save", spec.Save)
+	L.Register("Ignore", spec.Ignore)
+	L.Register("MatchSnapshot", spec.MatchSnapshot)
+
+	if err := L.DoString("return " + expr); err != nil {
+		t.Fatal(err)
+	}
+	return L.Get(-1)
+}
+
+func TestStdCheckMatchers(t *testing.T) {
+	ctx := WithSaveFunc(context.Background(), func(string, any) {})
+
+	tests := []struct {
+		name     string
+		expected string
+		actual   any
+		diff     string
+	}{
+		{
+			name:     "matches",
+			expected: `{ id = Matches("^[0-9a-f]{8}$") }`,
+			actual:   map[string]any{"id": "0123abcd"},
+		},
+		{
+			name:     "does not match",
+			expected: `{ id = Matches("^[0-9a-f]{8}$") }`,
+			actual:   map[string]any{"id": "not-an-id"},
+			diff:     `[[[ matches "^[0-9a-f]{8}$" ]]]`,
+		},
+		{
+			name:     "matches requires a string",
+			expected: `{ id = Matches(".*") }`,
+			actual:   map[string]any{"id": 1.0},
+			diff:     "matches",
+		},
+		{
+			name:     "between",
+			expected: `{ a = Between(1, 10), b = Between(1, 10), c = Between(1, 10) }`,
+			actual:   map[string]any{"a": 1.0, "b": int32(5), "c": uint8(10)},
+		},
+		{
+			name:     "not between",
+			expected: `{ count = Between(1, 10) }`,
+			actual:   map[string]any{"count": 11.0},
+			diff:     "[[[ between 1 and 10 ]]]",
+		},
+		{
+			name:     "greater and less than",
+			expected: `{ a = GreaterThan(0), b = LessThan(0) }`,
+			actual:   map[string]any{"a": int64(1), "b": -0.5},
+		},
+		{
+			name:     "not greater than",
+			expected: `{ count = GreaterThan(0) }`,
+			actual:   map[string]any{"count": 0.0},
+			diff:     "[[[ greater than 0 ]]]",
+		},
+		{
+			name:     "types",
+			expected: `{ s = IsType("string"), n = IsType("number"), b = IsType("boolean"), l = IsType("list"), m = IsType("map"), t = IsType("table"), null = IsType("null") }`,
+			actual: map[string]any{
+				"s":    "str",
+				"n":    1,
+				"b":    true,
+				"l":    []any{},
+				"m":    map[string]any{},
+				"t":    []any{1.0},
+				"null": nil,
+			},
+		},
+		{
+			name:     "wrong type",
+			expected: `{ list = IsType("list") }`,
+			actual:   map[string]any{"list": map[string]any{}},
+			diff:     "[[[ type list ]]]",
+		},
+		{
+			name:     "one of",
+			expected: `{ state = OneOf("ACTIVE", "DELETED"), n = OneOf(1, 2) }`,
+			actual:   map[string]any{"state": "DELETED", "n": int32(2)},
+		},
+		{
+			name:     "not one of",
+			expected: `{ state = OneOf("ACTIVE", "DELETED") }`,
+			actual:   map[string]any{"state": "UNKNOWN"},
+			diff:     `[[[ one of ["ACTIVE", "DELETED"] ]]]`,
+		},
+		{
+			name:     "iso date",
+			expected: `{ a = ISODate(), b = ISODate(), c = ISODate() }`,
+			actual:   map[string]any{"a": "2024-01-02T15:04:05.123Z", "b": "2024-01-02", "c": time.Now()},
+		},
+		{
+			name:     "not an iso date",
+			expected: `{ a = ISODate() }`,
+			actual:   map[string]any{"a": "02.01.2024"},
+			diff:     "[[[ iso date ]]]",
+		},
+		{
+			name:     "length",
+			expected: `{ users = Length(2), name = Length(3) }`,
+			actual:   map[string]any{"users": []any{1.0, 2.0}, "name": "abc"},
+		},
+		{
+			name:     "wrong length",
+			expected: `{ users = Length(1) }`,
+			actual:   map[string]any{"users": []any{1.0, 2.0}},
+			diff:     "[[[ length 1 ]]]",
+		},
+		{
+			name:     "unordered",
+			expected: `Unordered({ { id = 2 }, { id = GreaterThan(0) } })`,
+			actual:   []any{map[string]any{"id": 1.0}, map[string]any{"id": 2.0}},
+		},
+		{
+			name:     "unordered with extra elements",
+			expected: `Unordered({ { id = 2 } })`,
+			actual:   []any{map[string]any{"id": 1.0}, map[string]any{"id": 2.0}},
+			diff:     "runner.unordered",
+		},
+		{
+			name:     "unordered uses each element once",
+			expected: `{ ids = Unordered({ 1, 1 }) }`,
+			actual:   map[string]any{"ids": []any{1.0, 2.0}},
+			diff:     "runner.unordered",
+		},
+		{
+			name:     "contains elements",
+			expected: `{ ids = ContainsElements({ 3, 1 }) }`,
+			actual:   map[string]any{"ids": []any{1.0, 2.0, 3.0}},
+		},
+		{
+			name:     "missing elements",
+			expected: `{ ids = ContainsElements({ 4 }) }`,
+			actual:   map[string]any{"ids": []any{1.0, 2.0, 3.0}},
+			diff:     "runner.containsElements",
+		},
+		{
+			name:     "partial",
+			expected: `{ user = Partial({ name = "a", team = Partial({ slug = "t" }) }) }`,
+			actual: map[string]any{"user": map[string]any{
+				"id":   1.0,
+				"name": "a",
+				"team": map[string]any{"slug": "t", "id": 2.0},
+			}},
+		},
+		{
+			name:     "partial with wrong value",
+			expected: `Partial({ name = Matches("^b") })`,
+			actual:   map[string]any{"id": 1.0, "name": "a"},
+			diff:     `[[[ matches "^b" ]]]`,
+		},
+		{
+			name:     "partial with missing key",
+			expected: `Partial({ email = IsType("null") })`,
+			actual:   map[string]any{"id": 1.0},
+			diff:     "email",
+		},
+		{
+			name:     "in lists",
+			expected: `{ users = { { id = GreaterThan(0) }, { id = GreaterThan(1) } } }`,
+			actual:   map[string]any{"users": []any{map[string]any{"id": 1.0}, map[string]any{"id": 1.0}}},
+			diff:     "[[[ greater than 1 ]]]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := StdCheckError(ctx, evalExpected(t, tt.expected), tt.actual)
+			if tt.diff == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.diff) {
+				t.Errorf("expected error to contain %q, got:\n%v", tt.diff, err)
+			}
+		})
+	}
+}
+
+func TestStdCheckSaveInLists(t *testing.T) {
+	saved := map[string]any{}
+	ctx := WithSaveFunc(context.Background(), func(key string, value any) {
+		saved[key] = value
+	})
+
+	expected := evalExpected(t, `{
+		data = {
+			teams = Unordered({
+				{ slug = "b", id = Ignore(), members = Unordered({ { name = "y", id = Save("memberID") } }) },
+				{ slug = "a", id = Save("teamID"), members = Length(0) },
+			}),
+		},
+	}`)
+	actual := map[string]any{"data": map[string]any{"teams": []any{
+		map[string]any{"slug": "a", "id": "team-a", "members": []any{}},
+		map[string]any{"slug": "b", "id": "team-b", "members": []any{
+			map[string]any{"name": "y", "id": "member-y"},
+		}},
+	}}}
+
+	if err := StdCheckError(ctx, expected, actual); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{"teamID": "team-a", "memberID": "member-y"}
+	if diff := cmp.Diff(want, saved); diff != "" {
+		t.Errorf("saved mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStdCheckSnapshotsInMatchers(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		actual   any
+		changed  any
+	}{
+		{
+			name:     "partial",
+			expected: `Partial({ data = MatchSnapshot("partial") })`,
+			actual:   map[string]any{"data": map[string]any{"id": "a"}, "extra": true},
+			changed:  map[string]any{"data": map[string]any{"id": "b"}, "extra": true},
+		},
+		{
+			name:     "unordered",
+			expected: `Unordered({ MatchSnapshot("first"), { id = "b" } })`,
+			actual:   []any{map[string]any{"id": "a"}, map[string]any{"id": "b"}},
+			changed:  []any{map[string]any{"id": "c"}, map[string]any{"id": "b"}},
+		},
+		{
+			name:     "contains elements",
+			expected: `{ items = ContainsElements({ MatchSnapshot("item") }) }`,
+			actual:   map[string]any{"items": []any{"a", "b"}},
+			changed:  map[string]any{"items": []any{"c", "b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewSnapshotStore(filepath.Join(t.TempDir(), "snapshots.lua"), false)
+			ctx := WithSaveFunc(context.Background(), func(string, any) {})
+			ctx = WithSnapshots(ctx, store)
+
+			expected := evalExpected(t, tt.expected)
+			if !hasSnapshots(expected) {
+				t.Fatal("expected snapshots to be found")
+			}
+			// The first check writes the snapshot, the second compares against it
+			for range 2 {
+				if err := StdCheckError(ctx, expected, tt.actual); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := StdCheckError(ctx, expected, tt.changed); err == nil {
+				t.Error("expected a mismatch after the value changed")
+			}
+		})
+	}
+}
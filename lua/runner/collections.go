@@ -0,0 +1,221 @@
+package runner
+
+import (
+	"reflect"
+	"strconv"
+
+	"github.com/google/go-cmp/cmp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+type (
+	// unordered is the expected value of Unordered
+	unordered []any
+	// containsElements is the expected value of ContainsElements
+	containsElements []any
+)
+
+// saves records the paths of values to save. Elements of list matchers can
+// match any element in the actual list, so their paths are relative to the
+// element, and are resolved using the matched index after the comparison.
+type saves struct {
+	paths map[string]string
+	lists []*listSaves
+}
+
+type listSaves struct {
+	path  string
+	elems []element
+	// matches is the index of the actual element matched by each expected
+	// element. It's set by the comparer when the list matches.
+	matches []int
+}
+
+func newSaves() *saves {
+	return &saves{paths: map[string]string{}}
+}
+
+// resolve returns the paths of values to save, mapped to their names
+func (s *saves) resolve(prefix string) map[string]string {
+	ret := make(map[string]string, len(s.paths))
+	for path, name := range s.paths {
+		ret[prefix+path] = name
+	}
+	for _, l := range s.lists {
+		for i, j := range l.matches {
+			for path, name := range l.elems[i].saves.resolve(prefix + l.path + "." + strconv.Itoa(j)) {
+				ret[path] = name
+			}
+		}
+	}
+	return ret
+}
+
+// element is an expected list element converted on its own, with paths
+// relative to the element.
+type element struct {
+	value any
+	opts  cmp.Options
+	saves *saves
+}
+
+func convertElements(tbl *lua.LTable) []element {
+	var elems []element
+	tbl.ForEach(func(_, v lua.LValue) {
+		e := element{saves: newSaves()}
+		e.value, e.opts = convertToCheck("", v, e.saves, nil)
+		elems = append(elems, e)
+	})
+	return elems
+}
+
+// listMatcher compares the list at path against the expected elements in any
+// order. Unless exact is true, the actual list may contain other elements too.
+func listMatcher(path string, elems []element, exact bool, toSave *saves, opts cmp.Options) (any, cmp.Options) {
+	values := make([]any, len(elems))
+	for i, e := range elems {
+		values[i] = e.value
+	}
+
+	var expected any = unordered(values)
+	if !exact {
+		expected = containsElements(values)
+	}
+
+	ls := &listSaves{path: path, elems: elems}
+	toSave.lists = append(toSave.lists, ls)
+
+	return expected, append(opts, cmp.FilterPath(ignorePath(path), cmp.Comparer(func(a, b any) bool {
+		actual := b
+		if isListMatcher(b) {
+			actual = a
+		} else if !isListMatcher(a) {
+			return false
+		}
+
+		list, ok := toSlice(actual)
+		if !ok || len(list) < len(elems) || (exact && len(list) != len(elems)) {
+			return false
+		}
+
+		matches, ok := matchElements(elems, list)
+		if !ok {
+			return false
+		}
+
+		for i, j := range matches {
+			// Compare the matched elements again, so list matchers nested in
+			// the element refer to the matched element when saving values
+			cmp.Equal(elems[i].value, list[j], elems[i].opts...)
+		}
+		ls.matches = matches
+		return true
+	})))
+}
+
+func isListMatcher(v any) bool {
+	switch v.(type) {
+	case unordered, containsElements:
+		return true
+	}
+	return false
+}
+
+// matchElements finds a distinct actual element for every expected element,
+// using augmenting paths so an earlier match can be moved if needed. It
+// returns the index in actual for each expected element.
+func matchElements(elems []element, actual []any) ([]int, bool) {
+	equal := make([][]int8, len(elems))
+	for i := range equal {
+		equal[i] = make([]int8, len(actual))
+	}
+	isEqual := func(i, j int) bool {
+		if equal[i][j] == 0 {
+			equal[i][j] = -1
+			if cmp.Equal(elems[i].value, actual[j], elems[i].opts...) {
+				equal[i][j] = 1
+			}
+		}
+		return equal[i][j] == 1
+	}
+
+	// owner is the index of the expected element matched by each actual element
+	owner := make([]int, len(actual))
+	for j := range owner {
+		owner[j] = -1
+	}
+
+	var assign func(i int, seen []bool) bool
+	assign = func(i int, seen []bool) bool {
+		for j := range actual {
+			if seen[j] || !isEqual(i, j) {
+				continue
+			}
+			seen[j] = true
+			if owner[j] == -1 || assign(owner[j], seen) {
+				owner[j] = i
+				return true
+			}
+		}
+		return false
+	}
+
+	for i := range elems {
+		if !assign(i, make([]bool, len(actual))) {
+			return nil, false
+		}
+	}
+
+	matches := make([]int, len(elems))
+	for j, i := range owner {
+		if i >= 0 {
+			matches[i] = j
+		}
+	}
+	return matches, true
+}
+
+// partial ignores keys in the map at path which are not in expected
+func partial(path string, expected map[string]any) cmp.Option {
+	match := ignorePath(path)
+	return cmp.FilterPath(func(p cmp.Path) bool {
+		// Don't transform the result of the transformation again
+		if _, ok := p.Last().(cmp.Transform); ok {
+			return false
+		}
+		return match(p)
+	}, cmp.Transformer("Partial", func(m map[string]any) map[string]any {
+		ret := make(map[string]any, len(expected))
+		for k, v := range m {
+			if _, ok := expected[k]; ok {
+				ret[k] = v
+			}
+		}
+		return ret
+	}))
+}
+
+func toSlice(v any) ([]any, bool) {
+	if l, ok := v.([]any); ok {
+		return l, true
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	l := make([]any, rv.Len())
+	for i := range l {
+		l[i] = rv.Index(i).Interface()
+	}
+	return l, true
+}
+
+func hasLength(v any, n int) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
+		return rv.Len() == n
+	}
+	return false
+}
@@ -0,0 +1,346 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nais/tester/lua/reporter"
+	"github.com/nais/tester/lua/spec"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// defaultCookieURL is used for requests to in-process handlers, which only
+// have a path
+var defaultCookieURL = &url.URL{Scheme: "http", Host: "localhost", Path: "/"}
+
+// CookieJar stores cookies set by responses, and adds them to later
+// requests. Unlike net/http/cookiejar, the cookies can be listed. It's safe
+// for concurrent use.
+type CookieJar struct {
+	mu      sync.Mutex
+	cookies []*jarCookie
+}
+
+// jarCookie is a stored cookie. Cookies set without a domain are host-only,
+// and only sent to the host that set them, which is stored as their domain.
+type jarCookie struct {
+	http.Cookie
+	hostOnly bool
+}
+
+var _ http.CookieJar = (*CookieJar)(nil)
+
+func NewCookieJar() *CookieJar {
+	return &CookieJar{}
+}
+
+func WithCookieJar(ctx context.Context, jar *CookieJar) context.Context {
+	return context.WithValue(ctx, ctxCookieJar, jar)
+}
+
+func getCookieJar(ctx context.Context) *CookieJar {
+	jar, _ := ctx.Value(ctxCookieJar).(*CookieJar)
+	return jar
+}
+
+// SetCookies stores the cookies set by a response to u. Cookies without a
+// domain are host-only. Cookies that are expired, or have a negative MaxAge,
+// are removed from the jar.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	for _, c := range cookies {
+		c := jarCookie{Cookie: *c}
+		if c.Domain == "" {
+			c.Domain = u.Hostname()
+			c.hostOnly = true
+		}
+		c.Domain = strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+		if c.Path == "" || !strings.HasPrefix(c.Path, "/") {
+			c.Path = defaultCookiePath(u.Path)
+		}
+
+		j.cookies = slices.DeleteFunc(j.cookies, func(e *jarCookie) bool {
+			return e.Name == c.Name && e.Domain == c.Domain && e.Path == c.Path
+		})
+
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now)) {
+			continue
+		}
+		if c.MaxAge > 0 {
+			c.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		j.cookies = append(j.cookies, &c)
+	}
+}
+
+// Cookies returns the cookies to send in a request to u
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	host := strings.ToLower(u.Hostname())
+	reqPath := u.Path
+	if reqPath == "" {
+		reqPath = "/"
+	}
+	secure := u.Scheme == "https" || u.Scheme == "wss" || host == "localhost"
+
+	var ret []*http.Cookie
+	for _, c := range j.valid() {
+		if host != c.Domain && (c.hostOnly || !strings.HasSuffix(host, "."+c.Domain)) {
+			continue
+		}
+		if !pathMatch(reqPath, c.Path) || (c.Secure && !secure) {
+			continue
+		}
+		ret = append(ret, &http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	return ret
+}
+
+// All returns all cookies in the jar, sorted by name
+func (j *CookieJar) All() []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var ret []*http.Cookie
+	for _, c := range j.valid() {
+		cookie := c.Cookie
+		ret = append(ret, &cookie)
+	}
+	slices.SortStableFunc(ret, func(a, b *http.Cookie) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return ret
+}
+
+// Clear removes all cookies from the jar
+func (j *CookieJar) Clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cookies = nil
+}
+
+// valid removes expired cookies, and returns the rest. The lock must be held.
+func (j *CookieJar) valid() []*jarCookie {
+	now := time.Now()
+	j.cookies = slices.DeleteFunc(j.cookies, func(c *jarCookie) bool {
+		return !c.Expires.IsZero() && c.Expires.Before(now)
+	})
+	return j.cookies
+}
+
+// defaultCookiePath returns the directory of the request path, as described
+// in RFC 6265 section 5.1.4
+func defaultCookiePath(p string) string {
+	if p == "" || p[0] != '/' || strings.Count(p, "/") == 1 {
+		return "/"
+	}
+	return path.Dir(p)
+}
+
+func pathMatch(reqPath, cookiePath string) bool {
+	if reqPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(reqPath, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || reqPath[len(cookiePath)] == '/'
+}
+
+// cookieURL returns the URL used for cookies of the request. Requests to
+// in-process handlers are treated as requests to http://localhost.
+func cookieURL(u *url.URL) *url.URL {
+	if u.IsAbs() {
+		return u
+	}
+	return defaultCookieURL.ResolveReference(u)
+}
+
+// addCookies adds the cookies from the jar in ctx, if any, to the request
+func addCookies(ctx context.Context, req *http.Request) {
+	jar := getCookieJar(ctx)
+	if jar == nil {
+		return
+	}
+	for _, c := range jar.Cookies(cookieURL(req.URL)) {
+		req.AddCookie(c)
+	}
+}
+
+// storeCookies stores the cookies of the response in the jar in ctx, if any
+func storeCookies(ctx context.Context, req *http.Request, resp *http.Response) {
+	jar := getCookieJar(ctx)
+	if jar == nil {
+		return
+	}
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		jar.SetCookies(cookieURL(req.URL), cookies)
+	}
+}
+
+// ReportCookies logs the cookies in the jar in ctx, if any
+func ReportCookies(ctx context.Context) {
+	jar := getCookieJar(ctx)
+	if jar == nil {
+		return
+	}
+	cookies := jar.All()
+	if len(cookies) == 0 {
+		return
+	}
+
+	lines := make([]string, len(cookies))
+	for i, c := range cookies {
+		lines[i] = formatCookie(c)
+	}
+	Info(ctx, reporter.Info{
+		Type:     reporter.InfoTypeCookies,
+		Title:    fmt.Sprintf("Cookie jar (%d)", len(cookies)),
+		Content:  strings.Join(lines, "\n"),
+		Language: "text",
+	})
+}
+
+func formatCookie(c *http.Cookie) string {
+	s := fmt.Sprintf("%s=%s; Domain=%s; Path=%s", c.Name, c.Value, c.Domain, c.Path)
+	if !c.Expires.IsZero() {
+		s += "; Expires=" + c.Expires.UTC().Format(time.RFC3339)
+	}
+	if c.Secure {
+		s += "; Secure"
+	}
+	if c.HttpOnly {
+		s += "; HttpOnly"
+	}
+	return s
+}
+
+// cookieFunctions are the Lua functions to inspect and change the cookie
+// jar, available in both the REST and GQL runners. Cookies added with
+// setCookie belong to the host of target.
+func cookieFunctions(target httpTarget) []*spec.Function {
+	return []*spec.Function{
+		{
+			Name:    "cookies",
+			Doc:     "Return all cookies in the cookie jar",
+			Func:    luaCookies,
+			Returns: []spec.ArgumentType{spec.ArgumentTypeMetatable("{name: string, value: string, domain: string, path: string, expires?: string, secure: boolean, httpOnly: boolean}[]")},
+		},
+		{
+			Name: "cookie",
+			Args: []spec.Argument{
+				{Name: "name", Type: []spec.ArgumentType{spec.ArgumentTypeString}, Doc: "The cookie name"},
+			},
+			Doc:     "Return the value of the cookie, or nil if it's not in the cookie jar",
+			Func:    luaCookie,
+			Returns: []spec.ArgumentType{spec.ArgumentTypeString},
+		},
+		{
+			Name: "setCookie",
+			Args: []spec.Argument{
+				{Name: "name", Type: []spec.ArgumentType{spec.ArgumentTypeString}, Doc: "The cookie name"},
+				{Name: "value", Type: []spec.ArgumentType{spec.ArgumentTypeString}, Doc: "The cookie value"},
+				{
+					Name: "opts?",
+					Type: []spec.ArgumentType{spec.ArgumentTypeTableLiteral{Fields: []spec.ArgumentTypeTableLiteralField{
+						{Name: "domain?", Type: spec.ArgumentTypeString},
+						{Name: "path?", Type: spec.ArgumentTypeString},
+						{Name: "secure?", Type: spec.ArgumentTypeBoolean},
+						{Name: "httpOnly?", Type: spec.ArgumentTypeBoolean},
+					}}},
+					Doc: "Without a domain, the cookie is only sent to the host of the runner. The path defaults to /",
+				},
+			},
+			Doc:  "Add a cookie to the cookie jar",
+			Func: luaSetCookie(target.cookieURL()),
+		},
+		{
+			Name: "clearCookies",
+			Doc:  "Remove all cookies from the cookie jar",
+			Func: luaClearCookies,
+		},
+	}
+}
+
+func checkCookieJar(L *lua.LState) *CookieJar {
+	jar := getCookieJar(L.Context())
+	if jar == nil {
+		L.RaiseError("the cookie jar is not enabled")
+	}
+	return jar
+}
+
+func luaCookies(L *lua.LState) int {
+	jar := checkCookieJar(L)
+	ret := L.NewTable()
+	for _, c := range jar.All() {
+		tbl := L.NewTable()
+		tbl.RawSetString("name", lua.LString(c.Name))
+		tbl.RawSetString("value", lua.LString(c.Value))
+		tbl.RawSetString("domain", lua.LString(c.Domain))
+		tbl.RawSetString("path", lua.LString(c.Path))
+		if !c.Expires.IsZero() {
+			tbl.RawSetString("expires", lua.LString(c.Expires.UTC().Format(time.RFC3339)))
+		}
+		tbl.RawSetString("secure", lua.LBool(c.Secure))
+		tbl.RawSetString("httpOnly", lua.LBool(c.HttpOnly))
+		ret.Append(tbl)
+	}
+	L.Push(ret)
+	return 1
+}
+
+func luaCookie(L *lua.LState) int {
+	jar := checkCookieJar(L)
+	name := L.CheckString(1)
+	for _, c := range jar.All() {
+		if c.Name == name {
+			L.Push(lua.LString(c.Value))
+			return 1
+		}
+	}
+	L.Push(lua.LNil)
+	return 1
+}
+
+// luaSetCookie returns the Lua function adding cookies as if they were set
+// by a response from u
+func luaSetCookie(u *url.URL) lua.LGFunction {
+	return func(L *lua.LState) int {
+		jar := checkCookieJar(L)
+		c := &http.Cookie{
+			Name:  L.CheckString(1),
+			Value: L.CheckString(2),
+			Path:  "/",
+		}
+		if opts := L.OptTable(3, nil); opts != nil {
+			if v, ok := opts.RawGetString("domain").(lua.LString); ok {
+				c.Domain = string(v)
+			}
+			if v, ok := opts.RawGetString("path").(lua.LString); ok {
+				c.Path = string(v)
+			}
+			c.Secure = lua.LVAsBool(opts.RawGetString("secure"))
+			c.HttpOnly = lua.LVAsBool(opts.RawGetString("httpOnly"))
+		}
+		jar.SetCookies(u, []*http.Cookie{c})
+		return 0
+	}
+}
+
+func luaClearCookies(L *lua.LState) int {
+	checkCookieJar(L).Clear()
+	return 0
+}
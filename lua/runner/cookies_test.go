@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestCookieJarDomains(t *testing.T) {
+	jar := NewCookieJar()
+	jar.SetCookies(&url.URL{Scheme: "https", Host: "example.com", Path: "/login"}, []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: ".Example.com"},
+	})
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{url: "https://example.com/", want: []string{"host=1", "domain=2"}},
+		{url: "https://api.example.com/", want: []string{"domain=2"}},
+		{url: "https://example.org/", want: nil},
+		{url: "https://notexample.com/", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, c := range jar.Cookies(u) {
+				got = append(got, c.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// decodeBody decodes a response body based on its content type. JSON and XML
+// are decoded to maps and lists, while other bodies are returned as strings.
+// Bodies without a content type or with text/plain, which is what
+// http.DetectContentType gives JSON, are decoded as JSON if they are valid
+// JSON. Empty bodies are returned as nil.
+func decodeBody(contentType string, body []byte) (any, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, fmt.Errorf("unable to decode JSON: %w", err)
+		}
+		return v, nil
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		v, err := decodeXML(body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode XML: %w", err)
+		}
+		return v, nil
+	case mediaType == "" || mediaType == "text/plain":
+		var v any
+		if err := json.Unmarshal(body, &v); err == nil {
+			return v, nil
+		}
+	}
+	return string(body), nil
+}
+
+// decodeXML decodes an XML document to a map with the root element as the
+// only key. Elements with only text are strings, while other elements are
+// maps of their children, with attributes prefixed by @ and text in #text.
+// Repeated children become lists.
+func decodeXML(body []byte) (map[string]any, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("no root element")
+			}
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			v, err := decodeXMLElement(dec, start)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{start.Name.Local: v}, nil
+		}
+	}
+}
+
+func decodeXMLElement(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	children := map[string]any{}
+	for _, attr := range start.Attr {
+		children["@"+attr.Name.Local] = attr.Value
+	}
+
+	text := &strings.Builder{}
+	hasChildren := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			hasChildren = true
+			v, err := decodeXMLElement(dec, tok)
+			if err != nil {
+				return nil, err
+			}
+			name := tok.Name.Local
+			switch existing := children[name].(type) {
+			case nil:
+				children[name] = v
+			case []any:
+				children[name] = append(existing, v)
+			default:
+				children[name] = []any{existing, v}
+			}
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if !hasChildren && len(start.Attr) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				children["#text"] = s
+			}
+			return children, nil
+		}
+	}
+}
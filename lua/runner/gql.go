@@ -5,28 +5,81 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"mime/multipart"
 	"net/http"
-	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/nais/tester/lua/reporter"
 	"github.com/nais/tester/lua/spec"
+	"github.com/vektah/gqlparser/v2/ast"
 	lua "github.com/yuin/gopher-lua"
 )
 
 type GQL struct {
-	server  http.Handler
+	target  httpTarget
 	headers http.Header
+	opts    gqlOptions
 
-	results map[string]any
+	schemaOnce   sync.Once
+	loadedSchema *ast.Schema
+	schemaErr    error
+
+	results      map[string]any
+	subscription *subscription
 }
 
 var (
-	_ spec.Runner          = (*GQL)(nil)
-	_ spec.RunnerAfterTest = (*GQL)(nil)
+	_ spec.Runner             = (*GQL)(nil)
+	_ spec.RunnerAfterTest    = (*GQL)(nil)
+	_ spec.HasTypeAnnotations = (*GQL)(nil)
 )
 
-func NewGQLRunner(server http.Handler) *GQL {
-	return &GQL{server: server}
+type GQLOption func(*gqlOptions)
+
+type gqlOptions struct {
+	failOnUnexpectedErrors bool
+	sdl                    []string
+	introspect             bool
+	coverage               *GQLCoverage
+}
+
+// GQLFailOnUnexpectedErrors makes check fail when the response has errors,
+// unless the expected value has an errors key. Errors are allowed by default.
+func GQLFailOnUnexpectedErrors(enabled bool) GQLOption {
+	return func(o *gqlOptions) {
+		o.failOnUnexpectedErrors = enabled
+	}
+}
+
+func NewGQLRunner(server http.Handler, opts ...GQLOption) *GQL {
+	g := &GQL{target: handlerTarget(server)}
+	for _, opt := range opts {
+		opt(&g.opts)
+	}
+	return g
+}
+
+// NewGQLClientRunner creates a runner that sends queries to the GraphQL
+// endpoint at the given URL, instead of an in-process handler. Subscriptions
+// use the same endpoint. The client decides TLS settings, timeouts and
+// redirect policy. If it's nil, a client with a 30 second timeout that
+// doesn't follow redirects is used.
+func NewGQLClientRunner(endpoint string, client *http.Client, opts ...GQLOption) (*GQL, error) {
+	target, err := networkTarget(endpoint, client)
+	if err != nil {
+		return nil, err
+	}
+	g := &GQL{target: target}
+	for _, opt := range opts {
+		opt(&g.opts)
+	}
+	return g, nil
 }
 
 func (g *GQL) Name() string {
@@ -34,7 +87,12 @@ func (g *GQL) Name() string {
 }
 
 func (g *GQL) Functions() []*spec.Function {
-	return []*spec.Function{
+	check := StdCheckDefinition(g.check)
+	if schema, _ := g.schema(); schema != nil {
+		check.Args[0].Type = []spec.ArgumentType{spec.ArgumentTypeMetatable("gql.Response"), spec.ArgumentTypeUserData}
+	}
+
+	return append([]*spec.Function{
 		{
 			Name: "query",
 			Args: []spec.Argument{
@@ -44,15 +102,95 @@ func (g *GQL) Functions() []*spec.Function {
 					Doc:  "The query to run",
 				},
 				{
-					Name: "headers?",
-					Type: []spec.ArgumentType{spec.ArgumentTypeTable},
-					Doc:  "The headers to add to the HTTP request",
+					Name: "opts?",
+					Type: []spec.ArgumentType{spec.ArgumentTypeTableLiteral{Fields: []spec.ArgumentTypeTableLiteralField{
+						{Name: "variables?", Type: spec.ArgumentTypeMetatable("table<string, any>")},
+						{Name: "operationName?", Type: spec.ArgumentTypeString},
+						{Name: "headers?", Type: spec.ArgumentTypeMetatable("table<string, string>")},
+						{Name: "files?", Type: spec.ArgumentTypeMetatable("table<string, string|{content: string, filename?: string, contentType?: string}>")},
+					}}},
+					Doc: "Variables, operation name, headers and files to upload. Files are keyed by variable path, e.g. \"files.0\"",
+				},
+			},
+			Doc:     "Run the query, and return the decoded response",
+			Func:    g.query,
+			Returns: []spec.ArgumentType{spec.ArgumentTypeTable},
+		},
+		check,
+		{
+			Name: "checkErrors",
+			Args: []spec.Argument{
+				{
+					Name: "expected",
+					Type: []spec.ArgumentType{spec.ArgumentTypeMetatable("(string|{message?: any, path?: any, extensions?: table<string, any>})[]")},
+					Doc:  "Expected errors, matched in any order. Only the given fields are checked, and a string is short for { message = string }",
+				},
+			},
+			Doc:  "Check the errors of the response from query",
+			Func: g.checkErrors,
+		},
+		{
+			Name: "expectNoErrors",
+			Doc:  "Fail if the response from query has errors",
+			Func: g.expectNoErrors,
+		},
+		{
+			Name: "subscribe",
+			Args: []spec.Argument{
+				{
+					Name: "query",
+					Type: []spec.ArgumentType{spec.ArgumentTypeString},
+					Doc:  "The subscription to start",
+				},
+				{
+					Name: "opts?",
+					Type: []spec.ArgumentType{spec.ArgumentTypeTableLiteral{Fields: []spec.ArgumentTypeTableLiteralField{
+						{Name: "variables?", Type: spec.ArgumentTypeMetatable("table<string, any>")},
+						{Name: "operationName?", Type: spec.ArgumentTypeString},
+						{Name: "headers?", Type: spec.ArgumentTypeMetatable("table<string, string>")},
+						{Name: "transport?", Type: spec.StringEnum{"sse", "ws"}},
+					}}},
+					Doc: "Variables, operation name, headers and transport. The transport is either Server-Sent Events (default) or graphql-transport-ws",
 				},
 			},
-			Doc:  "Query comment",
-			Func: g.query,
+			Doc:  "Start a subscription, collecting events in the background. A previous subscription in the test is closed",
+			Func: g.subscribe,
+		},
+		{
+			Name: "nextEvent",
+			Args: []spec.Argument{
+				{
+					Name: "timeout?",
+					Type: []spec.ArgumentType{spec.ArgumentTypeString, spec.ArgumentTypeNumber},
+					Doc:  "How long to wait for the event, as a duration string or seconds. Defaults to 5s",
+				},
+			},
+			Doc:     "Wait for the next event of the subscription, and return it. The event is also used by check",
+			Func:    g.nextEvent,
+			Returns: []spec.ArgumentType{spec.ArgumentTypeTable},
+		},
+		{
+			Name: "checkEvents",
+			Args: []spec.Argument{
+				{
+					Name: "expected",
+					Type: []spec.ArgumentType{spec.ArgumentTypeTable, spec.ArgumentTypeUserData},
+					Doc:  "Expected list of events",
+				},
+				{
+					Name: "timeout?",
+					Type: []spec.ArgumentType{spec.ArgumentTypeString, spec.ArgumentTypeNumber},
+					Doc:  "How long to wait for matching events, as a duration string or seconds. Defaults to 5s",
+				},
+			},
+			Doc:  "Check all events received by the subscription. The check is retried as events arrive until it passes, or the subscription completes or times out",
+			Func: g.checkEvents,
+		},
+		{
+			Name: "unsubscribe",
+			Doc:  "Close the subscription",
+			Func: g.unsubscribe,
 		},
-		StdCheckDefinition(g.check),
 		{
 			Name: "addHeader",
 			Args: []spec.Argument{
@@ -70,58 +208,224 @@ func (g *GQL) Functions() []*spec.Function {
 			Doc:  "Add a header to the request",
 			Func: g.addHeader,
 		},
+	}, cookieFunctions(g.target)...)
+}
+
+// queryOptions are the options given to query
+type queryOptions struct {
+	variables     map[string]any
+	operationName *string
+	headers       *lua.LTable
+	files         []upload
+	// transport is used by subscribe, either "sse" or "ws"
+	transport string
+}
+
+// upload is a file sent using the GraphQL multipart request spec
+type upload struct {
+	// variable is the path to the variable, e.g. "file" or "files.0"
+	variable    string
+	filename    string
+	contentType string
+	content     []byte
+}
+
+// parseQueryOptions parses argument n of query. For compatibility, a table
+// without any of the option keys is used as headers.
+func parseQueryOptions(L *lua.LState, n int) queryOptions {
+	opts := queryOptions{variables: map[string]any{}, headers: L.NewTable(), transport: "sse"}
+	tbl := L.OptTable(n, nil)
+	if tbl == nil {
+		return opts
+	}
+
+	isOptions := false
+	for _, key := range []string{"variables", "operationName", "headers", "files", "transport"} {
+		if tbl.RawGetString(key) != lua.LNil {
+			isOptions = true
+		}
+	}
+	if !isOptions {
+		opts.headers = tbl
+		return opts
+	}
+
+	switch v := tbl.RawGetString("variables").(type) {
+	case *lua.LTable:
+		value, err := spec.ToGoValue(v)
+		if err != nil {
+			L.ArgError(n, "invalid variables: "+err.Error())
+		}
+		vars, ok := value.(map[string]any)
+		if !ok {
+			L.ArgError(n, "variables must be a table with string keys")
+		}
+		opts.variables = vars
+	case *lua.LNilType:
+	default:
+		L.ArgError(n, "variables must be a table")
+	}
+
+	switch v := tbl.RawGetString("operationName").(type) {
+	case lua.LString:
+		name := string(v)
+		opts.operationName = &name
+	case *lua.LNilType:
+	default:
+		L.ArgError(n, "operationName must be a string")
+	}
+
+	switch v := tbl.RawGetString("headers").(type) {
+	case *lua.LTable:
+		opts.headers = v
+	case *lua.LNilType:
+	default:
+		L.ArgError(n, "headers must be a table")
+	}
+
+	switch v := tbl.RawGetString("files").(type) {
+	case *lua.LTable:
+		v.ForEach(func(k, f lua.LValue) {
+			opts.files = append(opts.files, parseUpload(L, n, lua.LVAsString(k), f))
+		})
+		slices.SortFunc(opts.files, func(a, b upload) int {
+			return strings.Compare(a.variable, b.variable)
+		})
+	case *lua.LNilType:
+	default:
+		L.ArgError(n, "files must be a table")
 	}
+
+	switch v := tbl.RawGetString("transport").(type) {
+	case lua.LString:
+		if v != "sse" && v != "ws" {
+			L.ArgError(n, `transport must be "sse" or "ws"`)
+		}
+		opts.transport = string(v)
+	case *lua.LNilType:
+	default:
+		L.ArgError(n, "transport must be a string")
+	}
+
+	return opts
+}
+
+// parseUpload parses a file given either as a path relative to the Lua file,
+// or as a table with content, and optionally filename and contentType.
+func parseUpload(L *lua.LState, n int, variable string, v lua.LValue) upload {
+	u := upload{variable: variable, contentType: "application/octet-stream"}
+	switch v := v.(type) {
+	case lua.LString:
+		path := ResolvePath(L.Context(), string(v))
+		content, err := os.ReadFile(path)
+		if err != nil {
+			L.RaiseError("unable to read file for %q: %v", variable, err)
+		}
+		u.filename = filepath.Base(path)
+		u.content = content
+	case *lua.LTable:
+		content, ok := v.RawGetString("content").(lua.LString)
+		if !ok {
+			L.ArgError(n, fmt.Sprintf("file %q must have content", variable))
+		}
+		u.content = []byte(content)
+		u.filename = variable
+		if name, ok := v.RawGetString("filename").(lua.LString); ok {
+			u.filename = string(name)
+		}
+		if ct, ok := v.RawGetString("contentType").(lua.LString); ok {
+			u.contentType = string(ct)
+		}
+	default:
+		L.ArgError(n, fmt.Sprintf("file %q must be a path or a table", variable))
+	}
+	return u
 }
 
 func (g *GQL) query(L *lua.LState) int {
 	query := L.CheckString(1)
-	headers := L.OptTable(2, L.NewTable())
-
-	rec := httptest.NewRecorder()
-
-	b, err := json.Marshal(
-		struct {
-			OperationName *string        `json:"operationName"`
-			Variables     map[string]any `json:"variables"`
-			Query         string         `json:"query"`
-		}{
-			nil,
-			map[string]any{},
-			query,
-		},
+	opts := parseQueryOptions(L, 2)
+
+	operations := map[string]any{
+		"operationName": opts.operationName,
+		"variables":     opts.variables,
+		"query":         query,
+	}
+
+	var (
+		body        []byte
+		contentType = "application/json"
+		err         error
 	)
-	if err != nil {
-		panic(fmt.Sprintf("gql.Run: unable to marshal request: %v", err))
+	if len(opts.files) > 0 {
+		body, contentType, err = multipartRequest(operations, opts.files)
+		if err != nil {
+			L.RaiseError("unable to create multipart request: %v", err)
+		}
+	} else {
+		body, err = json.Marshal(operations)
+		if err != nil {
+			panic(fmt.Sprintf("gql.Run: unable to marshal request: %v", err))
+		}
 	}
 
 	// Log the query
+	var args []reporter.InfoArg
+	if opts.operationName != nil {
+		args = append(args, reporter.InfoArg{Name: "operationName", Value: *opts.operationName})
+	}
+	if len(opts.variables) > 0 {
+		vars, _ := json.MarshalIndent(opts.variables, "", "  ")
+		args = append(args, reporter.InfoArg{Name: "variables", Value: string(vars)})
+	}
+	for _, f := range opts.files {
+		args = append(args, reporter.InfoArg{Name: "file", Value: fmt.Sprintf("%s: %s (%d bytes)", f.variable, f.filename, len(f.content))})
+	}
 	Info(L.Context(), reporter.Info{
 		Type:     reporter.InfoTypeQuery,
 		Title:    "GraphQL Query",
 		Content:  query,
 		Language: "graphql",
+		Args:     args,
 	})
 
-	req, err := http.NewRequestWithContext(L.Context(), "POST", "/", bytes.NewReader(b))
+	if err := g.validate(query, opts.operationName); err != nil {
+		L.RaiseError("%v", err)
+	}
+
+	endpoint, _ := g.target.resolve("")
+	req, err := http.NewRequestWithContext(L.Context(), "POST", endpoint, bytes.NewReader(body))
 	if err != nil {
 		panic(fmt.Sprintf("gql.Run: unable to create request: %v", err))
 	}
 
-	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Content-Type", contentType)
 
 	for k := range g.headers {
 		req.Header.Add(k, g.headers.Get(k))
 	}
 
-	headers.ForEach(func(k, v lua.LValue) {
+	opts.headers.ForEach(func(k, v lua.LValue) {
 		req.Header.Add(k.String(), v.String())
 	})
+	addCookies(L.Context(), req)
 
-	g.server.ServeHTTP(rec, req)
+	rec, err := g.target.do(req)
+	if err != nil {
+		L.RaiseError("request failed: %v", err)
+	}
+	storeCookies(L.Context(), req, rec.Result())
 
 	g.results = map[string]any{}
 	if err := json.Unmarshal(rec.Body.Bytes(), &g.results); err != nil {
-		panic(fmt.Sprintf("gql.Run: unable to unmarshal response: %v", err))
+		g.results = nil
+		Info(L.Context(), reporter.Info{
+			Type:     reporter.InfoTypeResponse,
+			Title:    fmt.Sprintf("GraphQL Response (%d)", rec.Code),
+			Content:  rec.Body.String(),
+			Language: "text",
+		})
+		L.RaiseError("unable to decode response with status %d as JSON: %v\n%s", rec.Code, err, rec.Body.String())
 	}
 
 	// Log the response
@@ -133,15 +437,181 @@ func (g *GQL) query(L *lua.LState) int {
 		Language: "json",
 	})
 
-	return 0
+	L.Push(ToLuaValue(g.results))
+	return 1
+}
+
+// multipartRequest creates a request following the GraphQL multipart request
+// spec. The variables of the files are set to null in the operations, and the
+// map field maps each file part to its variable.
+func multipartRequest(operations map[string]any, files []upload) ([]byte, string, error) {
+	vars := operations["variables"].(map[string]any)
+	fileMap := map[string][]string{}
+	for i, f := range files {
+		if err := setNull(vars, strings.Split(f.variable, ".")); err != nil {
+			return nil, "", fmt.Errorf("variable %q: %w", f.variable, err)
+		}
+		fileMap[strconv.Itoa(i)] = []string{"variables." + f.variable}
+	}
+
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+
+	ops, err := json.Marshal(operations)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := w.WriteField("operations", string(ops)); err != nil {
+		return nil, "", err
+	}
+
+	m, err := json.Marshal(fileMap)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := w.WriteField("map", string(m)); err != nil {
+		return nil, "", err
+	}
+
+	for i, f := range files {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%d"; filename="%s"`, i, escapeQuotes(f.filename)))
+		h.Set("Content-Type", f.contentType)
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(f.content); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// setNull sets the value at path in v to nil. Keys are added to maps, while
+// list indexes must exist.
+func setNull(v any, path []string) error {
+	last := len(path) == 1
+	switch v := v.(type) {
+	case map[string]any:
+		if last {
+			v[path[0]] = nil
+			return nil
+		}
+		child, ok := v[path[0]]
+		if !ok || child == nil {
+			child = map[string]any{}
+			v[path[0]] = child
+		}
+		return setNull(child, path[1:])
+	case []any:
+		idx, err := strconv.Atoi(path[0])
+		if err != nil || idx < 0 || idx >= len(v) {
+			return fmt.Errorf("index %q not found in list", path[0])
+		}
+		if last {
+			v[idx] = nil
+			return nil
+		}
+		return setNull(v[idx], path[1:])
+	}
+	return fmt.Errorf("%q is not in a map or list", path[0])
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
 }
 
 func (g *GQL) check(L *lua.LState) int {
-	tbl := L.CheckTable(1)
+	tbl := CheckExpected(L, 1)
+	if g.opts.failOnUnexpectedErrors {
+		if t, ok := tbl.(*lua.LTable); ok && t.RawGetString("errors") == lua.LNil {
+			if errs := g.responseErrors(L); len(errs) > 0 {
+				L.RaiseError("unexpected errors in response:\n%s", formatErrors(errs))
+			}
+		}
+	}
 	StdCheck(L, tbl, g.results)
 	return 0
 }
 
+// responseErrors returns the errors of the last response. A response without
+// errors gives an empty list.
+func (g *GQL) responseErrors(L *lua.LState) []any {
+	if g.results == nil {
+		L.RaiseError("query not called")
+	}
+	errs, ok := g.results["errors"].([]any)
+	if !ok {
+		return []any{}
+	}
+	return errs
+}
+
+func formatErrors(errs []any) string {
+	b, _ := json.MarshalIndent(errs, "", "  ")
+	return string(b)
+}
+
+// checkErrors checks the errors of the last response. The expected errors may
+// be given in any order, and each error is only matched on the fields given.
+// A string is short for an error with that message.
+func (g *GQL) checkErrors(L *lua.LState) int {
+	tbl := L.CheckTable(1)
+	errs := g.responseErrors(L)
+
+	expected := L.NewTable()
+	tbl.ForEach(func(_, v lua.LValue) {
+		switch v := v.(type) {
+		case lua.LString:
+			e := L.NewTable()
+			e.RawSetString("message", v)
+			expected.Append(partialError(L, e))
+		case *lua.LTable:
+			expected.Append(partialError(L, v))
+		default:
+			expected.Append(v)
+		}
+	})
+
+	ud := L.NewUserData()
+	ud.Value = spec.UnorderedData{Elements: expected}
+	if err := StdCheckError(L.Context(), ud, errs); err != nil {
+		L.RaiseError("errors mismatch, got:\n%s\n%v", formatErrors(errs), err)
+	}
+	return 0
+}
+
+// partialError wraps the expected error, and its extensions, in Partial
+func partialError(L *lua.LState, e *lua.LTable) *lua.LUserData {
+	fields := L.NewTable()
+	e.ForEach(func(k, v lua.LValue) {
+		if ext, ok := v.(*lua.LTable); ok && lua.LVAsString(k) == "extensions" {
+			ud := L.NewUserData()
+			ud.Value = spec.PartialData{Fields: ext}
+			v = ud
+		}
+		fields.RawSet(k, v)
+	})
+
+	ud := L.NewUserData()
+	ud.Value = spec.PartialData{Fields: fields}
+	return ud
+}
+
+func (g *GQL) expectNoErrors(L *lua.LState) int {
+	if errs := g.responseErrors(L); len(errs) > 0 {
+		L.RaiseError("expected no errors, got:\n%s", formatErrors(errs))
+	}
+	return 0
+}
+
 func (g *GQL) addHeader(L *lua.LState) int {
 	key := L.CheckString(1)
 	value := L.CheckString(2)
@@ -157,4 +627,5 @@ func (g *GQL) addHeader(L *lua.LState) int {
 
 func (g *GQL) AfterTest(ctx context.Context) {
 	g.headers = nil
+	g.closeSubscription()
 }
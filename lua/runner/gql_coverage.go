@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// GQLCoverage records which fields of the schema are selected by the queries
+// run by one or more GQL runners. Runners record coverage when created with
+// GQLRecordCoverage, and only when they have a schema. It's safe for
+// concurrent use.
+type GQLCoverage struct {
+	mu     sync.Mutex
+	schema *ast.Schema
+	// hits is keyed by type name, then field name
+	hits map[string]map[string]int
+}
+
+func NewGQLCoverage() *GQLCoverage {
+	return &GQLCoverage{hits: map[string]map[string]int{}}
+}
+
+// GQLRecordCoverage records the fields selected by every query in coverage
+func GQLRecordCoverage(coverage *GQLCoverage) GQLOption {
+	return func(o *gqlOptions) {
+		o.coverage = coverage
+	}
+}
+
+// GQLCoverageReport is the coverage of the object and interface types in the
+// schema
+type GQLCoverageReport struct {
+	Covered int               `json:"covered"`
+	Total   int               `json:"total"`
+	Types   []GQLTypeCoverage `json:"types"`
+	// Uncovered lists the fields that were never selected, as Type.field
+	Uncovered []string `json:"uncovered"`
+}
+
+type GQLTypeCoverage struct {
+	Name    string             `json:"name"`
+	Covered int                `json:"covered"`
+	Total   int                `json:"total"`
+	Fields  []GQLFieldCoverage `json:"fields"`
+}
+
+type GQLFieldCoverage struct {
+	Name string `json:"name"`
+	Hits int    `json:"hits"`
+}
+
+// record counts the fields selected by the operation. All operations in the
+// document are recorded when operationName is empty.
+func (c *GQLCoverage) record(schema *ast.Schema, doc *ast.QueryDocument, operationName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.schema == nil {
+		c.schema = schema
+	}
+
+	for _, op := range doc.Operations {
+		if operationName != "" && op.Name != operationName {
+			continue
+		}
+		c.recordSelections(op.SelectionSet)
+	}
+}
+
+func (c *GQLCoverage) recordSelections(set ast.SelectionSet) {
+	for _, sel := range set {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if sel.ObjectDefinition != nil && !strings.HasPrefix(sel.Name, "__") {
+				fields, ok := c.hits[sel.ObjectDefinition.Name]
+				if !ok {
+					fields = map[string]int{}
+					c.hits[sel.ObjectDefinition.Name] = fields
+				}
+				fields[sel.Name]++
+			}
+			c.recordSelections(sel.SelectionSet)
+		case *ast.InlineFragment:
+			c.recordSelections(sel.SelectionSet)
+		case *ast.FragmentSpread:
+			if sel.Definition != nil {
+				c.recordSelections(sel.Definition.SelectionSet)
+			}
+		}
+	}
+}
+
+// Report returns the coverage so far. The report is empty until a query has
+// been recorded.
+func (c *GQLCoverage) Report() *GQLCoverageReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := &GQLCoverageReport{Types: []GQLTypeCoverage{}, Uncovered: []string{}}
+	if c.schema == nil {
+		return report
+	}
+
+	names := make([]string, 0, len(c.schema.Types))
+	for name, def := range c.schema.Types {
+		if (def.Kind == ast.Object || def.Kind == ast.Interface) && !def.BuiltIn && !strings.HasPrefix(name, "__") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		tc := GQLTypeCoverage{Name: name}
+		for _, f := range c.schema.Types[name].Fields {
+			if strings.HasPrefix(f.Name, "__") {
+				continue
+			}
+			hits := c.hits[name][f.Name]
+			tc.Fields = append(tc.Fields, GQLFieldCoverage{Name: f.Name, Hits: hits})
+			tc.Total++
+			if hits > 0 {
+				tc.Covered++
+			} else {
+				report.Uncovered = append(report.Uncovered, name+"."+f.Name)
+			}
+		}
+		report.Types = append(report.Types, tc)
+		report.Covered += tc.Covered
+		report.Total += tc.Total
+	}
+
+	return report
+}
+
+// WriteJSON writes the report as indented JSON
+func (c *GQLCoverage) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c.Report())
+}
@@ -0,0 +1,419 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// GQLSchema validates queries against the schema given as SDL, before they
+// are sent. The schema is also used to generate types for the Lua spec.
+func GQLSchema(sdl ...string) GQLOption {
+	return func(o *gqlOptions) {
+		o.sdl = sdl
+	}
+}
+
+// GQLIntrospectSchema is like GQLSchema, but the schema is loaded by running
+// an introspection query against the handler.
+func GQLIntrospectSchema() GQLOption {
+	return func(o *gqlOptions) {
+		o.introspect = true
+	}
+}
+
+// schema returns the schema, loading it on first use. It returns nil if the
+// runner has no schema.
+func (g *GQL) schema() (*ast.Schema, error) {
+	g.schemaOnce.Do(func() {
+		switch {
+		case len(g.opts.sdl) > 0:
+			var sources []*ast.Source
+			for i, sdl := range g.opts.sdl {
+				sources = append(sources, &ast.Source{Name: fmt.Sprintf("schema%d.graphqls", i), Input: sdl})
+			}
+			g.loadedSchema, g.schemaErr = gqlparser.LoadSchema(sources...)
+		case g.opts.introspect:
+			g.loadedSchema, g.schemaErr = introspectSchema(g.target)
+		}
+		if g.schemaErr != nil {
+			g.schemaErr = fmt.Errorf("unable to load schema: %w", g.schemaErr)
+		}
+	})
+	return g.loadedSchema, g.schemaErr
+}
+
+// validate validates the query against the schema, if the runner has one.
+// Valid queries are recorded in the coverage.
+func (g *GQL) validate(query string, operationName *string) error {
+	schema, err := g.schema()
+	if err != nil || schema == nil {
+		return err
+	}
+
+	doc, errs := gqlparser.LoadQuery(schema, query)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid query:\n%s", formatQueryErrors(errs))
+	}
+
+	if g.opts.coverage != nil {
+		name := ""
+		if operationName != nil {
+			name = *operationName
+		}
+		g.opts.coverage.record(schema, doc, name)
+	}
+	return nil
+}
+
+// formatQueryErrors formats the errors with their line and column in the query
+func formatQueryErrors(errs gqlerror.List) string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		if len(err.Locations) > 0 {
+			lines[i] = fmt.Sprintf("  %d:%d: %s", err.Locations[0].Line, err.Locations[0].Column, err.Message)
+		} else {
+			lines[i] = "  " + err.Message
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+const introspectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives {
+      name
+      isRepeatable
+      locations
+      args { ...InputValue }
+    }
+  }
+}
+
+fragment FullType on __Type {
+  kind
+  name
+  fields(includeDeprecated: true) {
+    name
+    args { ...InputValue }
+    type { ...TypeRef }
+  }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) { name }
+  possibleTypes { ...TypeRef }
+}
+
+fragment InputValue on __InputValue {
+  name
+  type { ...TypeRef }
+  defaultValue
+}
+
+fragment TypeRef on __Type {
+  kind
+  name
+  ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } } } }
+}`
+
+type introspectionTypeRef struct {
+	Kind   string                `json:"kind"`
+	Name   string                `json:"name"`
+	OfType *introspectionTypeRef `json:"ofType"`
+}
+
+func (t *introspectionTypeRef) String() string {
+	switch t.Kind {
+	case "NON_NULL":
+		return t.OfType.String() + "!"
+	case "LIST":
+		return "[" + t.OfType.String() + "]"
+	default:
+		return t.Name
+	}
+}
+
+type introspectionInputValue struct {
+	Name         string               `json:"name"`
+	Type         introspectionTypeRef `json:"type"`
+	DefaultValue *string              `json:"defaultValue"`
+}
+
+type introspectionType struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Fields []struct {
+		Name string                    `json:"name"`
+		Args []introspectionInputValue `json:"args"`
+		Type introspectionTypeRef      `json:"type"`
+	} `json:"fields"`
+	InputFields   []introspectionInputValue `json:"inputFields"`
+	Interfaces    []introspectionTypeRef    `json:"interfaces"`
+	EnumValues    []struct{ Name string }   `json:"enumValues"`
+	PossibleTypes []introspectionTypeRef    `json:"possibleTypes"`
+}
+
+type introspectionSchema struct {
+	QueryType        *struct{ Name string } `json:"queryType"`
+	MutationType     *struct{ Name string } `json:"mutationType"`
+	SubscriptionType *struct{ Name string } `json:"subscriptionType"`
+	Types            []introspectionType    `json:"types"`
+	Directives       []struct {
+		Name         string                    `json:"name"`
+		IsRepeatable bool                      `json:"isRepeatable"`
+		Locations    []string                  `json:"locations"`
+		Args         []introspectionInputValue `json:"args"`
+	} `json:"directives"`
+}
+
+// introspectSchema loads the schema by running an introspection query
+// against the target
+func introspectSchema(target httpTarget) (*ast.Schema, error) {
+	body, err := json.Marshal(map[string]any{"query": introspectionQuery})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, _ := target.resolve("")
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rec, err := target.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspection request failed: %w", err)
+	}
+
+	var resp struct {
+		Data struct {
+			Schema *introspectionSchema `json:"__schema"`
+		} `json:"data"`
+		Errors []gqlerror.Error `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("unable to decode introspection response with status %d: %w", rec.Code, err)
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("introspection failed: %s", resp.Errors[0].Message)
+	}
+	if resp.Data.Schema == nil {
+		return nil, fmt.Errorf("introspection response has no schema")
+	}
+
+	return gqlparser.LoadSchema(&ast.Source{Name: "introspection", Input: introspectionSDL(resp.Data.Schema)})
+}
+
+// introspectionSDL converts the introspection result to SDL. Types and
+// directives that are part of the gqlparser prelude are skipped.
+func introspectionSDL(s *introspectionSchema) string {
+	prelude, _ := gqlparser.LoadSchema()
+
+	sb := &strings.Builder{}
+	sb.WriteString("schema {\n")
+	if s.QueryType != nil {
+		sb.WriteString("  query: " + s.QueryType.Name + "\n")
+	}
+	if s.MutationType != nil {
+		sb.WriteString("  mutation: " + s.MutationType.Name + "\n")
+	}
+	if s.SubscriptionType != nil {
+		sb.WriteString("  subscription: " + s.SubscriptionType.Name + "\n")
+	}
+	sb.WriteString("}\n")
+
+	for _, d := range s.Directives {
+		if _, ok := prelude.Directives[d.Name]; ok {
+			continue
+		}
+		sb.WriteString("\ndirective @" + d.Name + inputValuesSDL("(", d.Args, ")"))
+		if d.IsRepeatable {
+			sb.WriteString(" repeatable")
+		}
+		sb.WriteString(" on " + strings.Join(d.Locations, " | ") + "\n")
+	}
+
+	for _, t := range s.Types {
+		if _, ok := prelude.Types[t.Name]; ok {
+			continue
+		}
+
+		sb.WriteString("\n")
+		switch t.Kind {
+		case "SCALAR":
+			sb.WriteString("scalar " + t.Name + "\n")
+		case "ENUM":
+			sb.WriteString("enum " + t.Name + " {\n")
+			for _, v := range t.EnumValues {
+				sb.WriteString("  " + v.Name + "\n")
+			}
+			sb.WriteString("}\n")
+		case "UNION":
+			names := make([]string, len(t.PossibleTypes))
+			for i, p := range t.PossibleTypes {
+				names[i] = p.Name
+			}
+			sb.WriteString("union " + t.Name + " = " + strings.Join(names, " | ") + "\n")
+		case "INPUT_OBJECT":
+			sb.WriteString("input " + t.Name + inputValuesSDL(" {\n  ", t.InputFields, "\n}") + "\n")
+		case "OBJECT", "INTERFACE":
+			if t.Kind == "OBJECT" {
+				sb.WriteString("type " + t.Name)
+			} else {
+				sb.WriteString("interface " + t.Name)
+			}
+			if len(t.Interfaces) > 0 {
+				names := make([]string, len(t.Interfaces))
+				for i, iface := range t.Interfaces {
+					names[i] = iface.Name
+				}
+				sb.WriteString(" implements " + strings.Join(names, " & "))
+			}
+			sb.WriteString(" {\n")
+			for _, f := range t.Fields {
+				sb.WriteString("  " + f.Name + inputValuesSDL("(", f.Args, ")") + ": " + f.Type.String() + "\n")
+			}
+			sb.WriteString("}\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// inputValuesSDL formats arguments or input fields, wrapped in open and close
+func inputValuesSDL(open string, values []introspectionInputValue, close string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	sep := ", "
+	if strings.Contains(open, "\n") {
+		sep = "\n  "
+	}
+
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = v.Name + ": " + v.Type.String()
+		if v.DefaultValue != nil {
+			parts[i] += " = " + *v.DefaultValue
+		}
+	}
+	return open + strings.Join(parts, sep) + close
+}
+
+// TypeAnnotations returns Lua type annotations for the schema. Each type in
+// the schema is a class prefixed with "gql.", and gql.Response is the type of
+// the check tables, with the fields of all root types in data.
+func (g *GQL) TypeAnnotations() (string, error) {
+	schema, err := g.schema()
+	if err != nil || schema == nil {
+		return "", err
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString("--- GraphQL types\n\n")
+
+	names := make([]string, 0, len(schema.Types))
+	for name, def := range schema.Types {
+		if !strings.HasPrefix(name, "__") && !def.BuiltIn {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		def := schema.Types[name]
+		switch def.Kind {
+		case ast.Scalar:
+			sb.WriteString("---@alias gql." + name + " " + scalarLuaType(name) + "\n\n")
+		case ast.Enum:
+			values := make([]string, len(def.EnumValues))
+			for i, v := range def.EnumValues {
+				values[i] = fmt.Sprintf("%q", v.Name)
+			}
+			sb.WriteString("---@alias gql." + name + " " + strings.Join(values, "|") + "\n\n")
+		case ast.Union:
+			types := make([]string, len(def.Types))
+			for i, t := range def.Types {
+				types[i] = "gql." + t
+			}
+			sb.WriteString("---@alias gql." + name + " " + strings.Join(types, "|") + "\n\n")
+		case ast.Object, ast.Interface, ast.InputObject:
+			sb.WriteString("---@class gql." + name + "\n")
+			for _, f := range def.Fields {
+				if strings.HasPrefix(f.Name, "__") {
+					continue
+				}
+				sb.WriteString("---@field " + f.Name + "? " + luaType(f.Type) + "\n")
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	roots := []*ast.Definition{schema.Query, schema.Mutation, schema.Subscription}
+
+	sb.WriteString("--- Data of a GraphQL response, with the fields of all root types\n")
+	sb.WriteString("---@class gql.Data\n")
+	seen := map[string]bool{}
+	for _, root := range roots {
+		if root == nil {
+			continue
+		}
+		for _, f := range root.Fields {
+			if strings.HasPrefix(f.Name, "__") || seen[f.Name] {
+				continue
+			}
+			seen[f.Name] = true
+			sb.WriteString("---@field " + f.Name + "? " + luaType(f.Type) + "\n")
+		}
+	}
+	sb.WriteString("\n")
+
+	sb.WriteString("--- GraphQL response\n")
+	sb.WriteString("---@class gql.Response\n")
+	sb.WriteString("---@field data? gql.Data|userdata\n")
+	sb.WriteString("---@field errors? table|userdata\n\n")
+
+	return sb.String(), nil
+}
+
+// luaType returns the Lua type of a field. Matchers can be used in place of
+// any value, so userdata is always allowed.
+func luaType(t *ast.Type) string {
+	return baseLuaType(t) + "|userdata"
+}
+
+func baseLuaType(t *ast.Type) string {
+	if t.Elem != nil {
+		return baseLuaType(t.Elem) + "[]"
+	}
+	switch t.NamedType {
+	case "Int", "Float", "String", "ID", "Boolean":
+		return scalarLuaType(t.NamedType)
+	}
+	return "gql." + t.NamedType
+}
+
+func scalarLuaType(name string) string {
+	switch name {
+	case "Int", "Float":
+		return "number"
+	case "String", "ID":
+		return "string"
+	case "Boolean":
+		return "boolean"
+	default:
+		return "any"
+	}
+}
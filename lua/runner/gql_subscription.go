@@ -0,0 +1,496 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nais/tester/lua/reporter"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// defaultEventTimeout is how long nextEvent and checkEvents wait for events
+// when no timeout is given
+const defaultEventTimeout = 5 * time.Second
+
+// subscription collects the events of a GraphQL subscription in the
+// background. It's safe for concurrent use.
+type subscription struct {
+	endpoint string
+	client   *http.Client
+	// stop stops the server started for in-process handlers
+	stop   func()
+	cancel context.CancelFunc
+	// closeFn is called when the subscription is closed before completion
+	closeFn func()
+
+	mu     sync.Mutex
+	events []map[string]any
+	// read is the number of events returned by nextEvent
+	read int
+	done bool
+	err  error
+	// changed is closed and replaced each time the state changes
+	changed chan struct{}
+}
+
+func newSubscription(target httpTarget) *subscription {
+	endpoint, client, stop := target.server()
+	return &subscription{
+		endpoint: endpoint,
+		client:   client,
+		stop:     stop,
+		changed:  make(chan struct{}),
+	}
+}
+
+func (s *subscription) add(event map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// finish marks the subscription as done. Only the first call has any effect.
+func (s *subscription) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.done = true
+	s.err = err
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// state returns a copy of the events received so far, the channel closed on
+// the next change, and whether the subscription is done
+func (s *subscription) state() ([]map[string]any, <-chan struct{}, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]any{}, s.events...), s.changed, s.done, s.err
+}
+
+// next waits for the next unread event
+func (s *subscription) next(ctx context.Context, timeout time.Duration) (map[string]any, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		if s.read < len(s.events) {
+			event := s.events[s.read]
+			s.read++
+			s.mu.Unlock()
+			return event, nil
+		}
+		done, err, changed := s.done, s.err, s.changed
+		s.mu.Unlock()
+
+		if done {
+			if err != nil {
+				return nil, fmt.Errorf("subscription failed: %w", err)
+			}
+			return nil, errors.New("subscription completed without more events")
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, fmt.Errorf("no event received within %v", timeout)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *subscription) close() {
+	s.finish(nil)
+	if s.closeFn != nil {
+		s.closeFn()
+	}
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.stop()
+}
+
+func (g *GQL) subscribe(L *lua.LState) int {
+	query := L.CheckString(1)
+	opts := parseQueryOptions(L, 2)
+	if len(opts.files) > 0 {
+		L.ArgError(2, "files are not supported for subscriptions")
+	}
+
+	operation := map[string]any{
+		"operationName": opts.operationName,
+		"variables":     opts.variables,
+		"query":         query,
+	}
+
+	headers := http.Header{}
+	for k := range g.headers {
+		headers.Add(k, g.headers.Get(k))
+	}
+	opts.headers.ForEach(func(k, v lua.LValue) {
+		headers.Add(k.String(), v.String())
+	})
+	if jar := getCookieJar(L.Context()); jar != nil {
+		endpoint, _ := g.target.resolve("")
+		u, _ := url.Parse(endpoint)
+		for _, c := range jar.Cookies(cookieURL(u)) {
+			headers.Add("Cookie", c.String())
+		}
+	}
+
+	// Log the subscription
+	args := []reporter.InfoArg{{Name: "transport", Value: opts.transport}}
+	if opts.operationName != nil {
+		args = append(args, reporter.InfoArg{Name: "operationName", Value: *opts.operationName})
+	}
+	if len(opts.variables) > 0 {
+		vars, _ := json.MarshalIndent(opts.variables, "", "  ")
+		args = append(args, reporter.InfoArg{Name: "variables", Value: string(vars)})
+	}
+	Info(L.Context(), reporter.Info{
+		Type:     reporter.InfoTypeQuery,
+		Title:    "GraphQL Subscription",
+		Content:  query,
+		Language: "graphql",
+		Args:     args,
+	})
+
+	if err := g.validate(query, opts.operationName); err != nil {
+		L.RaiseError("%v", err)
+	}
+
+	g.closeSubscription()
+
+	sub := newSubscription(g.target)
+	ctx, cancel := context.WithCancel(L.Context())
+	sub.cancel = cancel
+
+	var err error
+	switch opts.transport {
+	case "ws":
+		err = subscribeWS(ctx, sub, operation, headers)
+	default:
+		err = subscribeSSE(ctx, sub, operation, headers)
+	}
+	if err != nil {
+		sub.close()
+		L.RaiseError("unable to subscribe: %v", err)
+	}
+
+	g.subscription = sub
+	return 0
+}
+
+// subscribeSSE starts the subscription using Server-Sent Events, following
+// the GraphQL over SSE protocol in distinct connections mode
+func subscribeSSE(ctx context.Context, sub *subscription, operation map[string]any, headers http.Header) error {
+	body, err := json.Marshal(operation)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = headers.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := sub.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("expected an event stream, got status %d: %s", resp.StatusCode, b)
+	}
+
+	go func() {
+		defer resp.Body.Close()
+		sub.finish(readSSE(resp.Body, sub.add))
+	}()
+	return nil
+}
+
+// readSSE reads events from r until the stream completes. Events without a
+// name, or named next, are decoded as JSON and given to fn.
+func readSSE(r io.Reader, fn func(map[string]any)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var (
+		event string
+		data  []string
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event == "complete" {
+				return nil
+			}
+			if len(data) > 0 && (event == "" || event == "next") {
+				payload := map[string]any{}
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &payload); err != nil {
+					return fmt.Errorf("unable to decode event: %w", err)
+				}
+				fn(payload)
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// Comments are used to keep the connection alive
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event = value
+			case "data":
+				data = append(data, value)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// wsMessage is a message in the graphql-transport-ws protocol
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// subscribeWS starts the subscription over a WebSocket using the
+// graphql-transport-ws protocol. Headers are sent both with the upgrade
+// request and as the connection_init payload.
+func subscribeWS(ctx context.Context, sub *subscription, operation map[string]any, headers http.Header) error {
+	dialer := websocket.Dialer{
+		Subprotocols:     []string{"graphql-transport-ws"},
+		HandshakeTimeout: defaultEventTimeout,
+	}
+	if t, ok := sub.client.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = t.TLSClientConfig
+		dialer.Proxy = t.Proxy
+	}
+	wsURL := "ws" + strings.TrimPrefix(sub.endpoint, "http")
+	conn, resp, err := dialer.DialContext(ctx, wsURL, headers)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("%w (status %d)", err, resp.StatusCode)
+		}
+		return err
+	}
+
+	var writeMu sync.Mutex
+	write := func(msg wsMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(msg)
+	}
+
+	initPayload := map[string]string{}
+	for k := range headers {
+		initPayload[k] = headers.Get(k)
+	}
+	payload, _ := json.Marshal(initPayload)
+	if err := write(wsMessage{Type: "connection_init", Payload: payload}); err != nil {
+		conn.Close()
+		return err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(defaultEventTimeout))
+	var ack wsMessage
+	if err := conn.ReadJSON(&ack); err != nil {
+		conn.Close()
+		return fmt.Errorf("waiting for connection_ack: %w", err)
+	}
+	if ack.Type != "connection_ack" {
+		conn.Close()
+		return fmt.Errorf("expected connection_ack, got %s: %s", ack.Type, ack.Payload)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	payload, err = json.Marshal(operation)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if err := write(wsMessage{ID: "1", Type: "subscribe", Payload: payload}); err != nil {
+		conn.Close()
+		return err
+	}
+
+	sub.closeFn = func() {
+		_ = write(wsMessage{ID: "1", Type: "complete"})
+		conn.Close()
+	}
+
+	go func() {
+		for {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				if ctx.Err() != nil || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					sub.finish(nil)
+				} else {
+					sub.finish(err)
+				}
+				return
+			}
+
+			switch msg.Type {
+			case "next":
+				event := map[string]any{}
+				if err := json.Unmarshal(msg.Payload, &event); err != nil {
+					sub.finish(fmt.Errorf("unable to decode event: %w", err))
+					return
+				}
+				sub.add(event)
+			case "error":
+				var errs any
+				_ = json.Unmarshal(msg.Payload, &errs)
+				sub.add(map[string]any{"errors": errs})
+				sub.finish(nil)
+				return
+			case "complete":
+				sub.finish(nil)
+				return
+			case "ping":
+				_ = write(wsMessage{Type: "pong"})
+			}
+		}
+	}()
+	return nil
+}
+
+// eventTimeout returns the timeout given as argument n, either as a number of
+// seconds or as a duration string such as "500ms"
+func eventTimeout(L *lua.LState, n int) time.Duration {
+	switch v := L.Get(n).(type) {
+	case *lua.LNilType:
+		return defaultEventTimeout
+	case lua.LNumber:
+		return time.Duration(float64(v) * float64(time.Second))
+	case lua.LString:
+		d, err := time.ParseDuration(string(v))
+		if err != nil {
+			L.ArgError(n, fmt.Sprintf("invalid duration: %v", err))
+		}
+		return d
+	default:
+		L.ArgError(n, "timeout must be a string or a number")
+		return 0
+	}
+}
+
+func (g *GQL) checkSubscription(L *lua.LState) *subscription {
+	if g.subscription == nil {
+		L.RaiseError("subscribe not called")
+	}
+	return g.subscription
+}
+
+func (g *GQL) nextEvent(L *lua.LState) int {
+	sub := g.checkSubscription(L)
+	timeout := eventTimeout(L, 1)
+
+	event, err := sub.next(L.Context(), timeout)
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+
+	// Log the event
+	eventJSON, _ := json.MarshalIndent(event, "", "\t")
+	Info(L.Context(), reporter.Info{
+		Type:     reporter.InfoTypeResponse,
+		Title:    "GraphQL Subscription Event",
+		Content:  string(eventJSON),
+		Language: "json",
+	})
+
+	g.results = event
+	L.Push(ToLuaValue(event))
+	return 1
+}
+
+// checkEvents checks all events received so far against the expected value.
+// The check is retried as new events arrive, until it passes, the
+// subscription completes or the timeout is reached. Expected values with
+// snapshots, also inside matchers such as Unordered, are only checked once the
+// subscription completes or the timeout is reached, so the snapshot is written
+// from all events.
+func (g *GQL) checkEvents(L *lua.LState) int {
+	sub := g.checkSubscription(L)
+	expected := CheckExpected(L, 1)
+	timeout := eventTimeout(L, 2)
+	waitForAll := hasSnapshots(expected)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	timedOut := false
+	for {
+		events, changed, done, subErr := sub.state()
+		actual := make([]any, len(events))
+		for i, e := range events {
+			actual[i] = e
+		}
+
+		if done || timedOut {
+			if subErr != nil {
+				if err := StdCheckError(L.Context(), expected, actual); err != nil {
+					L.RaiseError("subscription failed: %v\n%v", subErr, err)
+				}
+				return 0
+			}
+			StdCheck(L, expected, actual)
+			return 0
+		}
+		if !waitForAll && StdCheckError(L.Context(), expected, actual) == nil {
+			return 0
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			timedOut = true
+		case <-L.Context().Done():
+			L.RaiseError("%v", L.Context().Err())
+		}
+	}
+}
+
+func (g *GQL) unsubscribe(L *lua.LState) int {
+	g.closeSubscription()
+	return 0
+}
+
+func (g *GQL) closeSubscription() {
+	if g.subscription != nil {
+		g.subscription.close()
+		g.subscription = nil
+	}
+}
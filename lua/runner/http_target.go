@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultClientTimeout is the timeout of the client used for network
+// endpoints when no client is given
+const defaultClientTimeout = 30 * time.Second
+
+// httpTarget is where the REST and GQL runners send requests: either an
+// in-process handler, or a server over the network
+type httpTarget struct {
+	handler http.Handler
+	baseURL *url.URL
+	client  *http.Client
+}
+
+func handlerTarget(handler http.Handler) httpTarget {
+	return httpTarget{handler: handler}
+}
+
+// networkTarget creates a target for the server at baseURL. A nil client is
+// replaced by one with a timeout, that doesn't follow redirects, so responses
+// are the same as from an in-process handler.
+func networkTarget(baseURL string, client *http.Client) (httpTarget, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return httpTarget{}, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return httpTarget{}, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+	if client == nil {
+		client = &http.Client{
+			Timeout:       defaultClientTimeout,
+			CheckRedirect: NoRedirects,
+		}
+	}
+	return httpTarget{baseURL: u, client: client}, nil
+}
+
+// NoRedirects can be used as CheckRedirect of an http.Client, to return
+// redirect responses instead of following them
+func NoRedirects(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// resolve returns the URL to request for path. For network targets, paths
+// are appended to the path of the base URL, and absolute URLs are used as is.
+func (t httpTarget) resolve(path string) (string, error) {
+	if t.baseURL == nil {
+		if path == "" {
+			return "/", nil
+		}
+		return path, nil
+	}
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	if u.IsAbs() {
+		return path, nil
+	}
+
+	ret := *t.baseURL
+	if u.Path != "" {
+		// Join the escaped paths, so escaped slashes are kept as they are
+		joined := strings.TrimSuffix(ret.EscapedPath(), "/") + "/" + strings.TrimPrefix(u.EscapedPath(), "/")
+		if ret.Path, err = url.PathUnescape(joined); err != nil {
+			return "", err
+		}
+		ret.RawPath = joined
+	}
+	if u.RawQuery != "" {
+		ret.RawQuery = u.RawQuery
+	}
+	return ret.String(), nil
+}
+
+// cookieURL returns the URL cookies are stored for, when they aren't set by
+// a response
+func (t httpTarget) cookieURL() *url.URL {
+	if t.baseURL == nil {
+		return defaultCookieURL
+	}
+	return t.baseURL
+}
+
+// do sends the request, and returns the buffered response. Responses from
+// network targets are copied to a recorder, so both kinds of targets can be
+// handled the same way.
+func (t httpTarget) do(req *http.Request) (*httptest.ResponseRecorder, error) {
+	rec := httptest.NewRecorder()
+	if t.baseURL == nil {
+		if t.handler == nil {
+			return nil, errors.New("no handler or base URL configured")
+		}
+		t.handler.ServeHTTP(rec, req)
+		return rec, nil
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response: %w", err)
+	}
+	for k, v := range resp.Header {
+		rec.Header()[k] = v
+	}
+	rec.WriteHeader(resp.StatusCode)
+	_, _ = rec.Write(body)
+	return rec, nil
+}
+
+// server returns the URL and client to use for long-lived requests, such as
+// subscriptions. In-process handlers are started on a local test server,
+// which is stopped by calling close. The client of network targets is used
+// without its timeout, which would also limit how long the body is read, so
+// long-lived requests are only limited by their context.
+func (t httpTarget) server() (endpoint string, client *http.Client, close func()) {
+	if t.baseURL != nil {
+		longLived := *t.client
+		longLived.Timeout = 0
+		return t.baseURL.String(), &longLived, func() {}
+	}
+	srv := httptest.NewServer(t.handler)
+	return srv.URL, srv.Client(), func() {
+		srv.CloseClientConnections()
+		srv.Close()
+	}
+}
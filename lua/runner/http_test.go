@@ -0,0 +1,929 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
+	"github.com/nais/tester/lua/reporter"
+	"github.com/nais/tester/lua/spec"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// runLua runs code with the runner functions available in the global t, and
+// Null, Length, Contains, Unordered and MatchSnapshot available as globals. The state is
+// returned so tests can inspect its context.
+func runLua(t *testing.T, ctx context.Context, r spec.Runner, code string) *lua.LState {
+	t.Helper()
+	L := lua.NewState()
+	t.Cleanup(L.Close)
+	L.SetContext(ctx)
+
+	funcs := map[string]lua.LGFunction{}
+	for _, f := range r.Functions() {
+		funcs[f.Name] = f.Func
+	}
+	L.SetGlobal("t", L.SetFuncs(L.NewTable(), funcs))
+	nullD := L.NewUserData()
+	nullD.Value = spec.Null{}
+	L.SetGlobal("Null", nullD)
+	L.Register("Length", spec.Length)
+	L.Register("Contains", spec.Contains)
+	L.Register("Unordered", spec.Unordered)
+	L.Register("MatchSnapshot", spec.MatchSnapshot)
+
+	if err := L.DoString(code); err != nil {
+		t.Fatal(err)
+	}
+	return L
+}
+
+func TestRESTSendReturnsResponse(t *testing.T) {
+	r := NewRestRunner(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("X-Test", "a")
+		w.Header().Add("X-Test", "b")
+		switch req.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(w, `{"id": 1, "tags": ["x", "y"]}`)
+		case "/text":
+			_, _ = io.WriteString(w, "hello")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	runLua(t, context.Background(), r, `
+		local res = t.send("POST", "/json", { name = "a" })
+		assert(res.status == 201, "status")
+		assert(res.headers["X-Test"] == "a, b", "headers")
+		assert(res.body.id == 1, "body.id")
+		assert(res.body.tags[2] == "y", "body.tags")
+
+		res = t.send("GET", "/text")
+		assert(res.status == 200, "text status")
+		assert(res.body == "hello", "text body")
+
+		res = t.send("DELETE", "/empty")
+		assert(res.status == 204, "empty status")
+		assert(res.body == nil, "empty body")
+	`)
+}
+
+func TestGQLQueryReturnsResponse(t *testing.T) {
+	g := NewGQLRunner(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(w, `{"data": {"users": [{"name": "a"}, {"name": "b"}]}}`)
+	}))
+
+	runLua(t, context.Background(), g, `
+		local res = t.query("{ users { name } }")
+		assert(#res.data.users == 2, "users")
+		assert(res.data.users[2].name == "b", "name")
+	`)
+}
+
+func TestGQLQueryOptions(t *testing.T) {
+	var (
+		operations map[string]any
+		fileMap    map[string][]string
+		files      = map[string]string{}
+		header     string
+	)
+	g := NewGQLRunner(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header.Get("X-Test")
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := req.ParseMultipartForm(1 << 20); err != nil {
+				t.Error(err)
+			}
+			_ = json.Unmarshal([]byte(req.FormValue("operations")), &operations)
+			_ = json.Unmarshal([]byte(req.FormValue("map")), &fileMap)
+			for name, fhs := range req.MultipartForm.File {
+				f, _ := fhs[0].Open()
+				b, _ := io.ReadAll(f)
+				files[name] = fhs[0].Filename + ":" + fhs[0].Header.Get("Content-Type") + ":" + string(b)
+			}
+		} else {
+			_ = json.NewDecoder(req.Body).Decode(&operations)
+		}
+		_, _ = io.WriteString(w, `{"data": {}}`)
+	}))
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "upload.txt"), []byte("from file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithFilename(context.Background(), filepath.Join(dir, "test.lua"))
+
+	runLua(t, ctx, g, `t.query("query Users($first: Int) { users(first: $first) { name } }", {
+		variables = { first = 10, filter = { name = Null } },
+		operationName = "Users",
+		headers = { ["X-Test"] = "yes" },
+	})`)
+
+	expected := map[string]any{
+		"query":         "query Users($first: Int) { users(first: $first) { name } }",
+		"operationName": "Users",
+		"variables":     map[string]any{"first": 10.0, "filter": map[string]any{"name": nil}},
+	}
+	if diff := cmp.Diff(expected, operations); diff != "" {
+		t.Errorf("operations mismatch (-want +got):\n%s", diff)
+	}
+	if header != "yes" {
+		t.Errorf("expected header to be set, got %q", header)
+	}
+
+	runLua(t, ctx, g, `t.query("mutation Upload($file: Upload!, $files: [Upload!]!) { upload }", {
+		variables = { files = { Null, Null } },
+		files = {
+			file = "upload.txt",
+			["files.1"] = { content = "inline", filename = "b.json", contentType = "application/json" },
+		},
+	})`)
+
+	expected = map[string]any{
+		"query":         "mutation Upload($file: Upload!, $files: [Upload!]!) { upload }",
+		"operationName": nil,
+		"variables":     map[string]any{"file": nil, "files": []any{nil, nil}},
+	}
+	if diff := cmp.Diff(expected, operations); diff != "" {
+		t.Errorf("operations mismatch (-want +got):\n%s", diff)
+	}
+	expectedMap := map[string][]string{"0": {"variables.file"}, "1": {"variables.files.1"}}
+	if diff := cmp.Diff(expectedMap, fileMap); diff != "" {
+		t.Errorf("map mismatch (-want +got):\n%s", diff)
+	}
+	expectedFiles := map[string]string{
+		"0": "upload.txt:application/octet-stream:from file",
+		"1": "b.json:application/json:inline",
+	}
+	if diff := cmp.Diff(expectedFiles, files); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+
+	runLua(t, ctx, g, `
+		local ok, err = pcall(t.query, "{ users { name } }", { variables = { ids = { "a", "b", first = 1 } } })
+		assert(not ok and string.find(err, 'table has both list elements and the key "first"', 1, true), tostring(err))
+	`)
+}
+
+func TestGQLSubscribeSSE(t *testing.T) {
+	var operation map[string]any
+	g := NewGQLRunner(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Accept") != "text/event-stream" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewDecoder(req.Body).Decode(&operation)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, ":\n\n")
+		for i := range 3 {
+			_, _ = fmt.Fprintf(w, "event: next\ndata: {\"data\": {\"counter\": %d}}\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+		_, _ = io.WriteString(w, "event: complete\n\n")
+	}))
+	t.Cleanup(func() { g.AfterTest(context.Background()) })
+	ctx := WithSaveFunc(context.Background(), func(string, any) {})
+
+	runLua(t, ctx, g, `
+		t.subscribe("subscription Counter($to: Int) { counter(to: $to) }", { variables = { to = 3 } })
+		local event = t.nextEvent()
+		assert(event.data.counter == 0, "first event")
+		t.check({ data = { counter = 0 } })
+
+		t.checkEvents({
+			{ data = { counter = 0 } },
+			{ data = { counter = 1 } },
+			{ data = { counter = 2 } },
+		})
+
+		t.nextEvent()
+		t.nextEvent()
+		local ok, err = pcall(t.nextEvent, 0.1)
+		assert(not ok and string.find(err, "completed"), "expected completion, got " .. tostring(err))
+	`)
+
+	if operation["query"] != "subscription Counter($to: Int) { counter(to: $to) }" {
+		t.Errorf("unexpected operation: %v", operation)
+	}
+}
+
+func TestGQLCheckEventsSnapshotAndDiff(t *testing.T) {
+	g := NewGQLRunner(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range 3 {
+			_, _ = fmt.Fprintf(w, "event: next\ndata: {\"data\": {\"counter\": %d}}\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+		_, _ = io.WriteString(w, "event: complete\n\n")
+	}))
+	t.Cleanup(func() { g.AfterTest(context.Background()) })
+
+	store := NewSnapshotStore(filepath.Join(t.TempDir(), "events.lua"), false)
+	ctx := WithSaveFunc(context.Background(), func(string, any) {})
+	ctx = WithSnapshots(ctx, store)
+
+	runLua(t, ctx, g, `
+		t.subscribe("subscription { counter }")
+		t.checkEvents(MatchSnapshot("events"))
+	`)
+
+	snapshot, ok, err := store.Get("events")
+	if err != nil || !ok {
+		t.Fatalf("expected snapshot, got %v, %v", ok, err)
+	}
+	if got := len(snapshot.([]any)); got != 3 {
+		t.Errorf("expected snapshot of all 3 events, got %d: %v", got, snapshot)
+	}
+
+	runLua(t, ctx, g, `
+		t.subscribe("subscription { counter }")
+		t.checkEvents(Unordered({ MatchSnapshot("a"), MatchSnapshot("b"), MatchSnapshot("c") }))
+	`)
+	for i, name := range []string{"a", "b", "c"} {
+		snapshot, ok, err := store.Get(name)
+		if err != nil || !ok {
+			t.Fatalf("expected snapshot %q, got %v, %v", name, ok, err)
+		}
+		want := map[string]any{"data": map[string]any{"counter": float64(i)}}
+		if diff := cmp.Diff(want, snapshot); diff != "" {
+			t.Errorf("snapshot %q mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+
+	L := runLua(t, ctx, g, `
+		t.subscribe("subscription { counter }")
+		local ok = pcall(t.checkEvents, { { data = { counter = 5 } } }, 1)
+		assert(not ok, "expected mismatch")
+	`)
+	checkErr, ok := GetCheckError(L.Context())
+	if !ok || checkErr.Diff == "" {
+		t.Errorf("expected a check error with a diff, got %v", checkErr)
+	}
+}
+
+func TestGQLSubscribeWS(t *testing.T) {
+	var (
+		initPayload map[string]any
+		operation   map[string]any
+		completed   = make(chan struct{})
+	)
+	upgrader := websocket.Upgrader{Subprotocols: []string{"graphql-transport-ws"}}
+	g := NewGQLRunner(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		var msg wsMessage
+		_ = conn.ReadJSON(&msg)
+		_ = json.Unmarshal(msg.Payload, &initPayload)
+		_ = conn.WriteJSON(wsMessage{Type: "connection_ack"})
+
+		_ = conn.ReadJSON(&msg)
+		_ = json.Unmarshal(msg.Payload, &operation)
+		_ = conn.WriteJSON(wsMessage{Type: "ping"})
+		for i := range 2 {
+			_ = conn.WriteJSON(wsMessage{ID: msg.ID, Type: "next", Payload: json.RawMessage(fmt.Sprintf(`{"data": {"counter": %d}}`, i))})
+		}
+
+		for {
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == "complete" {
+				close(completed)
+				return
+			}
+		}
+	}))
+
+	ctx := WithSaveFunc(context.Background(), func(string, any) {})
+	runLua(t, ctx, g, `
+		t.addHeader("Authorization", "Bearer token")
+		t.subscribe("subscription { counter }", { transport = "ws" })
+		t.checkEvents(Length(2))
+		t.checkEvents({ { data = { counter = 0 } }, { data = { counter = 1 } } })
+		t.unsubscribe()
+	`)
+
+	select {
+	case <-completed:
+	case <-time.After(time.Second):
+		t.Error("expected the subscription to be completed")
+	}
+	if initPayload["Authorization"] != "Bearer token" {
+		t.Errorf("expected headers in connection_init payload, got %v", initPayload)
+	}
+	if operation["query"] != "subscription { counter }" {
+		t.Errorf("unexpected operation: %v", operation)
+	}
+}
+
+func TestGQLCheckErrors(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Header.Get("X-Response") {
+		case "text":
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = io.WriteString(w, "bad gateway")
+		case "ok":
+			_, _ = io.WriteString(w, `{"data": {"user": {"name": "a"}}}`)
+		default:
+			_, _ = io.WriteString(w, `{
+				"data": {"user": null, "team": {"slug": "t"}},
+				"errors": [
+					{"message": "team not found", "path": ["team", "owner"]},
+					{"message": "user not found", "path": ["user"], "extensions": {"code": "NOT_FOUND", "id": "1"}}
+				]
+			}`)
+		}
+	})
+	ctx := WithSaveFunc(context.Background(), func(string, any) {})
+
+	runLua(t, ctx, NewGQLRunner(handler), `
+		t.query("{ user { name } team { slug owner } }")
+		t.checkErrors({
+			{ message = Contains("user"), path = { "user" }, extensions = { code = "NOT_FOUND" } },
+			"team not found",
+		})
+		t.check({ data = { user = Null, team = { slug = "t" } }, errors = Length(2) })
+
+		local ok, err = pcall(t.checkErrors, { "team not found" })
+		assert(not ok and string.find(err, "errors mismatch"), "expected errors mismatch, got " .. tostring(err))
+
+		ok, err = pcall(t.expectNoErrors)
+		assert(not ok and string.find(err, "user not found"), "expected no errors to fail, got " .. tostring(err))
+
+		t.query("{ user { name } }", { ["X-Response"] = "ok" })
+		t.expectNoErrors()
+		t.checkErrors({})
+
+		ok, err = pcall(t.query, "{ user { name } }", { ["X-Response"] = "text" })
+		assert(not ok and string.find(err, "status 502"), "expected decode error, got " .. tostring(err))
+	`)
+
+	runLua(t, ctx, NewGQLRunner(handler, GQLFailOnUnexpectedErrors(true)), `
+		t.query("{ user { name } team { slug owner } }")
+		local ok, err = pcall(t.check, { data = { user = Null, team = { slug = "t" } } })
+		assert(not ok and string.find(err, "unexpected errors"), "expected unexpected errors, got " .. tostring(err))
+
+		t.check({ data = { user = Null, team = { slug = "t" } }, errors = Length(2) })
+	`)
+}
+
+const testSchema = `
+type Query {
+	users(first: Int = 10): [User!]!
+}
+
+type User {
+	id: ID!
+	name: String!
+	role: Role
+}
+
+enum Role {
+	ADMIN
+	MEMBER
+}
+`
+
+func TestGQLSchemaValidation(t *testing.T) {
+	requests := 0
+	g := NewGQLRunner(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		_, _ = io.WriteString(w, `{"data": {"users": []}}`)
+	}), GQLSchema(testSchema))
+	ctx := WithSaveFunc(context.Background(), func(string, any) {})
+
+	runLua(t, ctx, g, `
+		t.query("{ users(first: 1) { id name role } }")
+
+		local ok, err = pcall(t.query, "{\n  users {\n    nme\n  }\n}")
+		assert(not ok, "expected validation error")
+		assert(string.find(err, "3:5: Cannot query field \"nme\" on type \"User\"", 1, true), err)
+	`)
+
+	if requests != 1 {
+		t.Errorf("expected invalid queries not to be sent, got %d requests", requests)
+	}
+}
+
+func TestGQLIntrospectSchema(t *testing.T) {
+	introspection := `{"data": {"__schema": {
+		"queryType": {"name": "Query"},
+		"mutationType": null,
+		"subscriptionType": null,
+		"directives": [],
+		"types": [
+			{"kind": "OBJECT", "name": "Query", "fields": [
+				{"name": "users", "args": [{"name": "first", "type": {"kind": "SCALAR", "name": "Int"}, "defaultValue": "10"}],
+				 "type": {"kind": "NON_NULL", "ofType": {"kind": "LIST", "ofType": {"kind": "NON_NULL", "ofType": {"kind": "OBJECT", "name": "User"}}}}}
+			], "interfaces": []},
+			{"kind": "OBJECT", "name": "User", "fields": [
+				{"name": "id", "args": [], "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "ID"}}},
+				{"name": "name", "args": [], "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "String"}}},
+				{"name": "role", "args": [], "type": {"kind": "ENUM", "name": "Role"}}
+			], "interfaces": []},
+			{"kind": "ENUM", "name": "Role", "enumValues": [{"name": "ADMIN"}, {"name": "MEMBER"}]},
+			{"kind": "SCALAR", "name": "String"},
+			{"kind": "OBJECT", "name": "__Schema", "fields": []}
+		]
+	}}}`
+	introspected := NewGQLRunner(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(w, introspection)
+	}), GQLIntrospectSchema())
+
+	got, err := introspected.TypeAnnotations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewGQLRunner(nil, GQLSchema(testSchema)).TypeAnnotations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("annotations mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, expected := range []string{
+		"---@alias gql.Role \"ADMIN\"|\"MEMBER\"\n",
+		"---@class gql.User\n---@field id? string|userdata\n---@field name? string|userdata\n---@field role? gql.Role|userdata\n",
+		"---@class gql.Data\n---@field users? gql.User[]|userdata\n",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("expected annotations to contain %q, got:\n%s", expected, got)
+		}
+	}
+	if strings.Contains(got, "gql.Query.") {
+		t.Errorf("expected no classes for root fields, got:\n%s", got)
+	}
+}
+
+func TestGQLCoverage(t *testing.T) {
+	coverage := NewGQLCoverage()
+	g := NewGQLRunner(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(w, `{"data": {}}`)
+	}), GQLSchema(testSchema), GQLRecordCoverage(coverage))
+	ctx := WithSaveFunc(context.Background(), func(string, any) {})
+
+	if report := coverage.Report(); report.Total != 0 {
+		t.Errorf("expected empty report before any query, got %+v", report)
+	}
+
+	runLua(t, ctx, g, `
+		t.query("{ users { id } }")
+		t.query([[
+			query A { users { ...UserFields } }
+			query B { users { role } }
+			fragment UserFields on User { id name __typename }
+		]], { operationName = "A" })
+	`)
+
+	want := &GQLCoverageReport{
+		Covered: 3,
+		Total:   4,
+		Types: []GQLTypeCoverage{
+			{Name: "Query", Covered: 1, Total: 1, Fields: []GQLFieldCoverage{{Name: "users", Hits: 2}}},
+			{Name: "User", Covered: 2, Total: 3, Fields: []GQLFieldCoverage{
+				{Name: "id", Hits: 2},
+				{Name: "name", Hits: 1},
+				{Name: "role", Hits: 0},
+			}},
+		},
+		Uncovered: []string{"User.role"},
+	}
+	if diff := cmp.Diff(want, coverage.Report()); diff != "" {
+		t.Errorf("report mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRESTSendOptions(t *testing.T) {
+	type request struct {
+		URL         string
+		ContentType string
+		Header      string
+		Body        string
+		Form        map[string][]string
+		Files       map[string]string
+	}
+	var got request
+	r := NewRestRunner(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = request{
+			URL:         req.URL.String(),
+			ContentType: req.Header.Get("Content-Type"),
+			Header:      req.Header.Get("X-Test"),
+		}
+		switch {
+		case strings.HasPrefix(got.ContentType, "multipart/form-data"):
+			if err := req.ParseMultipartForm(1 << 20); err != nil {
+				t.Error(err)
+			}
+			got.ContentType = "multipart/form-data"
+			got.Form = req.MultipartForm.Value
+			got.Files = map[string]string{}
+			for name, fhs := range req.MultipartForm.File {
+				f, _ := fhs[0].Open()
+				b, _ := io.ReadAll(f)
+				got.Files[name] = fhs[0].Filename + ":" + fhs[0].Header.Get("Content-Type") + ":" + string(b)
+			}
+		case got.ContentType == "application/x-www-form-urlencoded":
+			_ = req.ParseForm()
+			got.Form = req.PostForm
+		default:
+			b, _ := io.ReadAll(req.Body)
+			got.Body = string(b)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "avatar.png"), []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithFilename(context.Background(), filepath.Join(dir, "test.lua"))
+
+	tests := []struct {
+		name string
+		code string
+		want request
+	}{
+		{
+			name: "json table with query",
+			code: `t.send("POST", "/users?a=1", { name = "a", tags = { "x" } }, { query = { b = 2, c = { "x", "y" } }, headers = { ["X-Test"] = "yes" } })`,
+			want: request{
+				URL:         "/users?a=1&b=2&c=x&c=y",
+				ContentType: "application/json",
+				Header:      "yes",
+				Body:        `{"name":"a","tags":["x"]}`,
+			},
+		},
+		{
+			name: "empty table is an object",
+			code: `t.send("POST", "/users", { tags = {} })`,
+			want: request{URL: "/users", ContentType: "application/json", Body: `{"tags":{}}`},
+		},
+		{
+			name: "string with content type",
+			code: `t.send("PUT", "/text", "hello", { contentType = "text/plain" })`,
+			want: request{URL: "/text", ContentType: "text/plain", Body: "hello"},
+		},
+		{
+			name: "form",
+			code: `t.send("POST", "/login", nil, { form = { user = "a", scope = { "x", "y" } } })`,
+			want: request{
+				URL:         "/login",
+				ContentType: "application/x-www-form-urlencoded",
+				Form:        map[string][]string{"user": {"a"}, "scope": {"x", "y"}},
+			},
+		},
+		{
+			name: "multipart",
+			code: `t.send("POST", "/upload", nil, { multipart = {
+				name = "a",
+				avatar = { file = "avatar.png", contentType = "image/png" },
+				data = { content = "{}", filename = "data.json", contentType = "application/json" },
+			} })`,
+			want: request{
+				URL:         "/upload",
+				ContentType: "multipart/form-data",
+				Form:        map[string][]string{"name": {"a"}},
+				Files: map[string]string{
+					"avatar": "avatar.png:image/png:png",
+					"data":   "data.json:application/json:{}",
+				},
+			},
+		},
+		{
+			name: "base64",
+			code: `t.send("POST", "/raw", nil, { base64 = "AAEC/w==" })`,
+			want: request{URL: "/raw", ContentType: "application/octet-stream", Body: "\x00\x01\x02\xff"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = request{}
+			runLua(t, ctx, r, tt.code)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("request mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	runLua(t, ctx, r, `
+		local ok, err = pcall(t.send, "POST", "/", "body", { form = { a = "b" } })
+		assert(not ok and string.find(err, "only one of"), "expected conflicting bodies to fail, got " .. tostring(err))
+
+		ok, err = pcall(t.send, "POST", "/", { "a", b = 1 })
+		assert(not ok and string.find(err, 'table has both list elements and the key "b"', 1, true), tostring(err))
+	`)
+}
+
+func TestRESTCheckResponses(t *testing.T) {
+	r := NewRestRunner(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/list":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `[{"id": 1}, {"id": 2}]`)
+		case "/xml":
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			_, _ = io.WriteString(w, `<?xml version="1.0"?><users count="2"><user id="1">a</user><user id="2"><name>b</name></user></users>`)
+		case "/csv":
+			w.Header().Set("Content-Type", "text/csv")
+			_, _ = io.WriteString(w, "id,name\n1,a\n")
+		case "/sniffed":
+			_, _ = io.WriteString(w, `{"message": "hello"}`)
+		case "/created":
+			w.Header().Set("Location", "/users/1")
+			w.Header().Add("Set-Cookie", "session=abc; HttpOnly")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	ctx := WithSaveFunc(context.Background(), func(string, any) {})
+
+	runLua(t, ctx, r, `
+		t.send("GET", "/list")
+		t.check(200, { { id = 1 }, { id = 2 } })
+
+		t.send("GET", "/xml")
+		t.check(200, { users = { ["@count"] = "2", user = { { ["@id"] = "1", ["#text"] = "a" }, { ["@id"] = "2", name = "b" } } } })
+
+		local res = t.send("GET", "/csv")
+		assert(res.body == "id,name\n1,a\n", "csv body")
+		t.check(200, Contains("1,a"))
+		t.checkBody("id,name\n1,a\n")
+		t.checkBody(Contains("id,name"))
+
+		t.send("GET", "/sniffed")
+		t.check(200, { message = "hello" })
+
+		t.send("POST", "/created")
+		t.checkHeaders({ location = "/users/1", ["Set-Cookie"] = Contains("session=abc") })
+		local ok, err = pcall(t.checkHeaders, { Location = "/users/2" })
+		assert(not ok, "expected header mismatch")
+
+		t.send("DELETE", "/users/1")
+		t.check(204, Null)
+		t.checkBody("")
+	`)
+}
+
+func TestRESTClientRunner(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/api", Secure: true})
+			http.Redirect(w, req, "/api/home", http.StatusFound)
+		default:
+			session := ""
+			if c, err := req.Cookie("session"); err == nil {
+				session = c.Value
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"path":    req.URL.EscapedPath(),
+				"query":   req.URL.RawQuery,
+				"session": session,
+			})
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client := srv.Client()
+	client.CheckRedirect = NoRedirects
+	r, err := NewRestClientRunner(srv.URL+"/api/", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithSaveFunc(context.Background(), func(string, any) {})
+	ctx = WithCookieJar(ctx, NewCookieJar())
+
+	runLua(t, ctx, r, `
+		t.send("GET", "/users", nil, { query = { page = 2 } })
+		t.check(200, { path = "/api/users", query = "page=2", session = "" })
+
+		t.send("POST", "login")
+		t.check(302, Null)
+		t.checkHeaders({ Location = "/api/home" })
+		assert(t.cookie("session") == "abc", "expected session cookie")
+
+		t.send("GET", "/me")
+		t.check(200, { path = "/api/me", query = "", session = "abc" })
+
+		t.send("GET", "/users/a%2Fb")
+		t.check(200, { path = "/api/users/a%2Fb", query = "", session = "abc" })
+
+		t.clearCookies()
+		t.setCookie("session", "manual")
+		t.send("GET", "/me")
+		t.check(200, { path = "/api/me", query = "", session = "manual" })
+	`)
+
+	srv.Close()
+	runLua(t, ctx, r, `
+		local ok, err = pcall(t.send, "GET", "/users")
+		assert(not ok and string.find(err, "request failed"), "expected request error, got " .. tostring(err))
+	`)
+}
+
+func TestGQLClientRunner(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/graphql" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if req.Header.Get("Accept") == "text/event-stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := range 2 {
+				_, _ = fmt.Fprintf(w, "event: next\ndata: {\"data\": {\"counter\": %d}}\n\n", i)
+				w.(http.Flusher).Flush()
+				time.Sleep(100 * time.Millisecond)
+			}
+			_, _ = io.WriteString(w, "event: complete\n\n")
+			return
+		}
+		_, _ = io.WriteString(w, `{"data": {"hello": "world"}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	// The client timeout must not cut off subscriptions
+	client := srv.Client()
+	client.Timeout = 50 * time.Millisecond
+	g, err := NewGQLClientRunner(srv.URL+"/graphql", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { g.AfterTest(context.Background()) })
+	ctx := WithSaveFunc(context.Background(), func(string, any) {})
+
+	runLua(t, ctx, g, `
+		t.query("{ hello }")
+		t.check({ data = { hello = "world" } })
+
+		t.subscribe("subscription { counter }")
+		t.checkEvents({ { data = { counter = 0 } }, { data = { counter = 1 } } })
+	`)
+
+	if _, err := NewGQLClientRunner("localhost:8080", nil); err == nil {
+		t.Error("expected error for URL without scheme")
+	}
+}
+
+const testOpenAPI = `
+openapi: 3.0.3
+info: { title: Users, version: "1" }
+servers:
+  - url: /api
+paths:
+  /users:
+    post:
+      operationId: createUser
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [name]
+              properties:
+                name: { type: string }
+      responses:
+        "201":
+          description: Created
+          content:
+            application/json:
+              schema: { $ref: "#/components/schemas/User" }
+  /users/{id}:
+    get:
+      operationId: getUser
+      parameters:
+        - { name: id, in: path, required: true, schema: { type: integer } }
+      responses:
+        "200":
+          description: OK
+          content:
+            application/json:
+              schema: { $ref: "#/components/schemas/User" }
+    delete:
+      operationId: deleteUser
+      parameters:
+        - { name: id, in: path, required: true, schema: { type: integer } }
+      responses:
+        "204": { description: Deleted }
+  /users/me:
+    get:
+      operationId: me
+      responses:
+        "200":
+          description: OK
+          content:
+            application/json:
+              schema: { $ref: "#/components/schemas/User" }
+components:
+  schemas:
+    User:
+      type: object
+      required: [id, name]
+      properties:
+        id: { type: integer }
+        name: { type: string }
+`
+
+func TestRESTOpenAPI(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.Method == "POST":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(w, `{"id": 1, "name": "a"}`)
+		case req.URL.Path == "/api/users/2":
+			_, _ = io.WriteString(w, `{"id": "2"}`)
+		case req.URL.Path == "/api/users/404":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = io.WriteString(w, `{"id": 1, "name": "a"}`)
+		}
+	})
+	ctx := WithSaveFunc(context.Background(), func(string, any) {})
+
+	coverage := NewRestCoverage()
+	r := NewRestRunner(handler, RestOpenAPI([]byte(testOpenAPI)), RestRecordCoverage(coverage))
+	runLua(t, ctx, r, `
+		t.send("GET", "/api/users/1")
+		t.check(200, { id = 1, name = "a" })
+		t.send("GET", "/api/users/me")
+		t.send("POST", "/api/users", { name = "a" })
+
+		local function fails(pattern, ...)
+			local ok, err = pcall(t.send, ...)
+			assert(not ok and string.find(err, pattern, 1, true), "expected " .. pattern .. ", got " .. tostring(err))
+		end
+		fails('request body: property "name" is missing at /name', "POST", "/api/users", { age = 1 })
+		fails("response: response body doesn't match schema #/components/schemas/User: value must be an integer at /id", "GET", "/api/users/2")
+		fails("response: status is not supported", "GET", "/api/users/404")
+		fails("GET /api/users is not in the OpenAPI document, the path /users only has POST", "GET", "/api/users")
+		fails("GET /api/groups is not in the OpenAPI document", "GET", "/api/groups")
+		fails('request parameter "id" in path: value abc', "GET", "/api/users/abc")
+
+		t.send("GET", "/api/users/404", nil, { openapi = false })
+		t.check(404, Null)
+	`)
+
+	expected := &RestCoverageReport{
+		Covered: 3,
+		Total:   4,
+		Operations: []RestOperationCoverage{
+			{Method: "POST", Path: "/users", OperationID: "createUser", Hits: 2},
+			{Method: "GET", Path: "/users/me", OperationID: "me", Hits: 1},
+			{Method: "DELETE", Path: "/users/{id}", OperationID: "deleteUser"},
+			{Method: "GET", Path: "/users/{id}", OperationID: "getUser", Hits: 4},
+		},
+		Uncovered: []string{"DELETE /users/{id}"},
+	}
+	if diff := cmp.Diff(expected, coverage.Report()); diff != "" {
+		t.Errorf("coverage mismatch (-want +got):\n%s", diff)
+	}
+
+	var warnings []string
+	ctx = WithReporter(ctx, infoFunc(func(info reporter.Info) {
+		if info.Type == reporter.InfoTypeWarning {
+			warnings = append(warnings, info.Content)
+		}
+	}))
+	runLua(t, ctx, NewRestRunner(handler, RestOpenAPI([]byte(testOpenAPI)), RestOpenAPIWarnOnly()), `
+		t.send("GET", "/api/users/2")
+		t.check(200, { id = "2" })
+	`)
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "response: response body doesn't match schema") {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+}
+
+// infoFunc is a reporter that only passes Info calls to the function
+type infoFunc func(reporter.Info)
+
+func (f infoFunc) RunFile(context.Context, string, func(reporter.Reporter))         {}
+func (f infoFunc) RunTest(context.Context, string, string, func(reporter.Reporter)) {}
+func (f infoFunc) ReportError(*reporter.Error)                                      {}
+func (f infoFunc) Info(info reporter.Info)                                          { f(info) }
+func (f infoFunc) Skip(string)                                                      {}
+func (f infoFunc) Todo()                                                            {}
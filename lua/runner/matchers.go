@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// matcher ensures the value at path satisfies fn. The placeholder is used as
+// the expected value, so it's shown in the diff if the value doesn't match.
+func matcher(path, placeholder string, opts cmp.Options, fn func(v any) bool) (any, cmp.Options) {
+	return placeholder, append(opts, cmp.FilterPath(ignorePath(path), cmp.Comparer(func(a, b any) bool {
+		if s, ok := a.(string); ok && s == placeholder {
+			return fn(b)
+		}
+		if s, ok := b.(string); ok && s == placeholder {
+			return fn(a)
+		}
+		return false
+	})))
+}
+
+// toFloat converts any Go number to a float64
+func toFloat(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	}
+	return 0, false
+}
+
+// isType reports whether v is of the given Lua type. Lists and maps are both
+// tables in Lua, so table accepts either.
+func isType(v any, typ string) bool {
+	if typ == "null" {
+		return v == nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch typ {
+	case "string":
+		return rv.Kind() == reflect.String
+	case "number":
+		_, ok := toFloat(v)
+		return ok
+	case "boolean":
+		return rv.Kind() == reflect.Bool
+	case "list":
+		return rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array
+	case "map":
+		return rv.Kind() == reflect.Map || rv.Kind() == reflect.Struct
+	case "table":
+		return isType(v, "list") || isType(v, "map")
+	}
+	return false
+}
+
+// equalScalar compares a value given to a matcher with an actual value, where
+// numbers of any type are equal if their values are equal.
+func equalScalar(expected, actual any) bool {
+	if n, ok := expected.(float64); ok {
+		a, ok := toFloat(actual)
+		return ok && a == n
+	}
+	return expected == actual
+}
+
+// isISODate reports whether v is a time, or a string containing an RFC3339
+// timestamp or a date on the form YYYY-MM-DD.
+func isISODate(v any) bool {
+	switch v := v.(type) {
+	case time.Time:
+		return true
+	case string:
+		if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return true
+		}
+		_, err := time.Parse(time.DateOnly, v)
+		return err == nil
+	}
+	return false
+}
+
+func formatValues(values []any) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		b, _ := json.Marshal(v)
+		s[i] = string(b)
+	}
+	return strings.Join(s, ", ")
+}
+
+// satisfies calls the Lua predicate fn with v. Errors raised by fn are not
+// caught, so they fail the test like any other error.
+func satisfies(L *lua.LState, fn *lua.LFunction, v any) bool {
+	L.Push(fn)
+	L.Push(ToLuaValue(v))
+	L.Call(1, 1)
+	ret := L.Get(-1)
+	L.Pop(1)
+	return lua.LVAsBool(ret)
+}
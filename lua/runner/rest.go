@@ -3,22 +3,34 @@ package runner
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"slices"
 	"strings"
+	"sync"
 
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/nais/tester/lua/reporter"
 	"github.com/nais/tester/lua/spec"
 	lua "github.com/yuin/gopher-lua"
 )
 
 type REST struct {
-	server   http.Handler
+	target   httpTarget
+	opts     restOptions
 	response *httptest.ResponseRecorder
 	headers  http.Header
+
+	docOnce   sync.Once
+	loadedDoc *openapi3.T
+	docErr    error
 }
 
 var (
@@ -26,8 +38,29 @@ var (
 	_ spec.RunnerAfterTest = (*REST)(nil)
 )
 
-func NewRestRunner(server http.Handler) *REST {
-	return &REST{server: server}
+func NewRestRunner(server http.Handler, opts ...RestOption) *REST {
+	r := &REST{target: handlerTarget(server)}
+	for _, opt := range opts {
+		opt(&r.opts)
+	}
+	return r
+}
+
+// NewRestClientRunner creates a runner that sends requests to the server at
+// baseURL, instead of an in-process handler. Paths given to send are
+// appended to the path of baseURL. The client decides TLS settings,
+// timeouts and redirect policy. If it's nil, a client with a 30 second
+// timeout that doesn't follow redirects is used.
+func NewRestClientRunner(baseURL string, client *http.Client, opts ...RestOption) (*REST, error) {
+	target, err := networkTarget(baseURL, client)
+	if err != nil {
+		return nil, err
+	}
+	r := &REST{target: target}
+	for _, opt := range opts {
+		opt(&r.opts)
+	}
+	return r, nil
 }
 
 func (r *REST) Name() string {
@@ -35,7 +68,7 @@ func (r *REST) Name() string {
 }
 
 func (s *REST) Functions() []*spec.Function {
-	return []*spec.Function{
+	return append([]*spec.Function{
 		{
 			Name: "send",
 			Args: []spec.Argument{
@@ -47,16 +80,34 @@ func (s *REST) Functions() []*spec.Function {
 				{
 					Name: "path",
 					Type: []spec.ArgumentType{spec.ArgumentTypeString},
-					Doc:  "The path to query",
+					Doc:  "The path to query. For runners against a base URL, the path is appended to it",
 				},
 				{
 					Name: "body?",
 					Type: []spec.ArgumentType{spec.ArgumentTypeString, spec.ArgumentTypeTable},
-					Doc:  "The body to send",
+					Doc:  "The body to send. Tables are sent as JSON",
+				},
+				{
+					Name: "opts?",
+					Type: []spec.ArgumentType{spec.ArgumentTypeTableLiteral{Fields: []spec.ArgumentTypeTableLiteralField{
+						{Name: "query?", Type: spec.ArgumentTypeMetatable("table<string, string|number|boolean|(string|number|boolean)[]>")},
+						{Name: "form?", Type: spec.ArgumentTypeMetatable("table<string, string|number|boolean|(string|number|boolean)[]>")},
+						{Name: "multipart?", Type: spec.ArgumentTypeMetatable("table<string, string|number|{file?: string, content?: string, filename?: string, contentType?: string}>")},
+						{Name: "base64?", Type: spec.ArgumentTypeString},
+						{Name: "contentType?", Type: spec.ArgumentTypeString},
+						{Name: "headers?", Type: spec.ArgumentTypeMetatable("table<string, string>")},
+						{Name: "openapi?", Type: spec.ArgumentTypeBoolean},
+					}}},
+					Doc: "Query parameters, headers and content type. The body can instead be given as a form, multipart fields and files, or base64 encoded bytes. Set openapi to false to skip OpenAPI validation, e.g. when testing invalid requests",
 				},
 			},
-			Doc:  "Send http request",
+			Doc:  "Send http request, and return the response. The body is decoded if it's JSON",
 			Func: s.send,
+			Returns: []spec.ArgumentType{spec.ArgumentTypeTableLiteral{Fields: []spec.ArgumentTypeTableLiteralField{
+				{Name: "status", Type: spec.ArgumentTypeNumber},
+				{Name: "headers", Type: spec.ArgumentTypeMetatable("table<string, string>")},
+				{Name: "body", Type: spec.ArgumentTypeAny},
+			}}},
 		},
 		{
 			Name: "addHeader",
@@ -77,14 +128,253 @@ func (s *REST) Functions() []*spec.Function {
 				},
 				{
 					Name: "resp",
-					Type: []spec.ArgumentType{spec.ArgumentTypeTable},
+					Type: []spec.ArgumentType{spec.ArgumentTypeTable, spec.ArgumentTypeUserData},
 					Doc:  "Expected response",
 				},
 			},
-			Doc:  "Check the response done by send",
+			Doc:  "Check the response done by send. The body is decoded based on the content type: JSON and XML are decoded to tables, other bodies are strings, and empty bodies are nil",
 			Func: s.check,
 		},
+		{
+			Name: "checkHeaders",
+			Args: []spec.Argument{
+				{
+					Name: "headers",
+					Type: []spec.ArgumentType{spec.ArgumentTypeMetatable("table<string, string|userdata>")},
+					Doc:  "Expected headers. Only the given headers are checked, and multiple values are joined with commas",
+				},
+			},
+			Doc:  "Check the headers of the response done by send",
+			Func: s.checkHeaders,
+		},
+		{
+			Name: "checkBody",
+			Args: []spec.Argument{
+				{
+					Name: "body",
+					Type: []spec.ArgumentType{spec.ArgumentTypeString, spec.ArgumentTypeUserData},
+					Doc:  "Expected body, or a matcher",
+				},
+			},
+			Doc:  "Check the raw body of the response done by send",
+			Func: s.checkBody,
+		},
+	}, cookieFunctions(s.target)...)
+}
+
+// sendOptions are the options given to send
+type sendOptions struct {
+	query       url.Values
+	form        url.Values
+	multipart   []multipartField
+	base64      *string
+	contentType string
+	headers     *lua.LTable
+	// skipOpenAPI disables OpenAPI validation of the request
+	skipOpenAPI bool
+}
+
+// multipartField is a field in a multipart/form-data body. Files have an
+// upload, other fields only a value.
+type multipartField struct {
+	name  string
+	value string
+	file  *upload
+}
+
+// parseSendOptions parses argument n of send
+func parseSendOptions(L *lua.LState, n int) sendOptions {
+	opts := sendOptions{headers: L.NewTable()}
+	tbl := L.OptTable(n, nil)
+	if tbl == nil {
+		return opts
+	}
+
+	tbl.ForEach(func(k, v lua.LValue) {
+		switch key := lua.LVAsString(k); key {
+		case "query":
+			opts.query = urlValues(L, n, key, v)
+		case "form":
+			opts.form = urlValues(L, n, key, v)
+		case "multipart":
+			fields, ok := v.(*lua.LTable)
+			if !ok {
+				L.ArgError(n, "multipart must be a table")
+			}
+			fields.ForEach(func(k, v lua.LValue) {
+				opts.multipart = append(opts.multipart, parseMultipartField(L, n, lua.LVAsString(k), v))
+			})
+			slices.SortFunc(opts.multipart, func(a, b multipartField) int {
+				return strings.Compare(a.name, b.name)
+			})
+		case "base64":
+			s, ok := v.(lua.LString)
+			if !ok {
+				L.ArgError(n, "base64 must be a string")
+			}
+			encoded := string(s)
+			opts.base64 = &encoded
+		case "contentType":
+			s, ok := v.(lua.LString)
+			if !ok {
+				L.ArgError(n, "contentType must be a string")
+			}
+			opts.contentType = string(s)
+		case "headers":
+			h, ok := v.(*lua.LTable)
+			if !ok {
+				L.ArgError(n, "headers must be a table")
+			}
+			opts.headers = h
+		case "openapi":
+			b, ok := v.(lua.LBool)
+			if !ok {
+				L.ArgError(n, "openapi must be a boolean")
+			}
+			opts.skipOpenAPI = !bool(b)
+		default:
+			L.ArgError(n, fmt.Sprintf("unknown option %q", key))
+		}
+	})
+
+	return opts
+}
+
+// urlValues converts a table to url values. A list value adds the key once
+// for each element.
+func urlValues(L *lua.LState, n int, name string, v lua.LValue) url.Values {
+	tbl, ok := v.(*lua.LTable)
+	if !ok {
+		L.ArgError(n, name+" must be a table")
+	}
+
+	values := url.Values{}
+	tbl.ForEach(func(k, v lua.LValue) {
+		key := lua.LVAsString(k)
+		if list, ok := v.(*lua.LTable); ok {
+			list.ForEach(func(_, v lua.LValue) {
+				values.Add(key, lua.LVAsString(v))
+			})
+			return
+		}
+		values.Add(key, lua.LVAsString(v))
+	})
+	return values
+}
+
+// parseMultipartField parses a multipart field. Strings and numbers are
+// sent as values, while tables are files, given either by a path relative
+// to the Lua file in file, or by content.
+func parseMultipartField(L *lua.LState, n int, name string, v lua.LValue) multipartField {
+	tbl, ok := v.(*lua.LTable)
+	if !ok {
+		return multipartField{name: name, value: lua.LVAsString(v)}
+	}
+
+	var u upload
+	if path, ok := tbl.RawGetString("file").(lua.LString); ok {
+		u = parseUpload(L, n, name, path)
+		if ct, ok := tbl.RawGetString("contentType").(lua.LString); ok {
+			u.contentType = string(ct)
+		}
+		if filename, ok := tbl.RawGetString("filename").(lua.LString); ok {
+			u.filename = string(filename)
+		}
+	} else {
+		u = parseUpload(L, n, name, tbl)
+	}
+	return multipartField{name: name, file: &u}
+}
+
+// body returns the body to send, its content type and a description for
+// the request log
+func (o sendOptions) body(L *lua.LState, body lua.LValue) ([]byte, string, string) {
+	given := 0
+	if body != lua.LNil {
+		given++
+	}
+	if o.form != nil {
+		given++
+	}
+	if o.multipart != nil {
+		given++
+	}
+	if o.base64 != nil {
+		given++
+	}
+	if given > 1 {
+		L.ArgError(4, "only one of body, form, multipart and base64 can be given")
+	}
+
+	switch {
+	case o.form != nil:
+		encoded := o.form.Encode()
+		return []byte(encoded), "application/x-www-form-urlencoded", encoded
+	case o.multipart != nil:
+		b, contentType, err := multipartBody(o.multipart)
+		if err != nil {
+			L.RaiseError("unable to create multipart body: %v", err)
+		}
+		var desc []string
+		for _, f := range o.multipart {
+			if f.file != nil {
+				desc = append(desc, fmt.Sprintf("%s: %s (%s, %d bytes)", f.name, f.file.filename, f.file.contentType, len(f.file.content)))
+			} else {
+				desc = append(desc, fmt.Sprintf("%s: %s", f.name, f.value))
+			}
+		}
+		return b, contentType, strings.Join(desc, "\n")
+	case o.base64 != nil:
+		b, err := base64.StdEncoding.DecodeString(*o.base64)
+		if err != nil {
+			L.ArgError(4, fmt.Sprintf("invalid base64: %v", err))
+		}
+		return b, "application/octet-stream", fmt.Sprintf("<%d bytes>", len(b))
+	}
+
+	switch body := body.(type) {
+	case lua.LString:
+		return []byte(body), "", string(body)
+	case *lua.LTable:
+		v, err := spec.ToGoValue(body)
+		if err != nil {
+			L.RaiseError("invalid body: %v", err)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			L.RaiseError("unable to marshal table: %v", err)
+		}
+		return b, "application/json", string(b)
 	}
+	return nil, "", ""
+}
+
+func multipartBody(fields []multipartField) ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for _, f := range fields {
+		if f.file == nil {
+			if err := w.WriteField(f.name, f.value); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(f.name), escapeQuotes(f.file.filename)))
+		h.Set("Content-Type", f.file.contentType)
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(f.file.content); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
 }
 
 func (r *REST) send(L *lua.LState) int {
@@ -95,22 +385,30 @@ func (r *REST) send(L *lua.LState) int {
 	ctx := L.Context()
 	method := L.CheckString(1)
 	path := L.CheckString(2)
-	var body io.Reader
-	var bodyContent string
-	if L.GetTop() > 2 {
-		switch L.Get(3).(type) {
-		case lua.LString:
-			bodyContent = L.CheckString(3)
-			body = strings.NewReader(bodyContent)
-		case *lua.LTable:
-			tbl := L.CheckTable(3)
-			b, err := json.Marshal(tbl)
-			if err != nil {
-				L.RaiseError("unable to marshal table: %v", err)
-			}
-			bodyContent = string(b)
-			body = bytes.NewReader(b)
+	opts := parseSendOptions(L, 4)
+
+	bodyArg := L.Get(3)
+	switch bodyArg.(type) {
+	case lua.LString, *lua.LTable, *lua.LNilType:
+	default:
+		L.ArgError(3, "body must be a string or a table")
+	}
+	body, contentType, bodyContent := opts.body(L, bodyArg)
+	if opts.contentType != "" {
+		contentType = opts.contentType
+	}
+
+	if len(opts.query) > 0 {
+		u, err := url.Parse(path)
+		if err != nil {
+			L.ArgError(2, fmt.Sprintf("invalid path: %v", err))
+		}
+		q := u.Query()
+		for k, v := range opts.query {
+			q[k] = append(q[k], v...)
 		}
+		u.RawQuery = q.Encode()
+		path = u.String()
 	}
 
 	// Log the request
@@ -118,24 +416,45 @@ func (r *REST) send(L *lua.LState) int {
 	if bodyContent != "" {
 		requestInfo += "\n\n" + bodyContent
 	}
+	var args []reporter.InfoArg
+	if contentType != "" {
+		args = append(args, reporter.InfoArg{Name: "Content-Type", Value: contentType})
+	}
 	Info(ctx, reporter.Info{
 		Type:     reporter.InfoTypeRequest,
 		Title:    "HTTP Request",
 		Content:  requestInfo,
 		Language: "text",
+		Args:     args,
 	})
 
-	req, err := http.NewRequestWithContext(ctx, method, path, body)
+	reqURL, err := r.target.resolve(path)
+	if err != nil {
+		L.ArgError(2, fmt.Sprintf("invalid path: %v", err))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bytes.NewReader(body))
 	if err != nil {
 		panic(fmt.Errorf("rest.Run: unable to create request: %w", err))
 	}
 
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
 	for k := range r.headers {
 		req.Header.Add(k, r.headers.Get(k))
 	}
 
-	r.response = httptest.NewRecorder()
-	r.server.ServeHTTP(r.response, req)
+	opts.headers.ForEach(func(k, v lua.LValue) {
+		req.Header.Add(k.String(), v.String())
+	})
+	addCookies(ctx, req)
+
+	r.response, err = r.target.do(req)
+	if err != nil {
+		L.RaiseError("request failed: %v", err)
+	}
+	storeCookies(ctx, req, r.response.Result())
 
 	// Log the response
 	Info(ctx, reporter.Info{
@@ -145,7 +464,64 @@ func (r *REST) send(L *lua.LState) int {
 		Language: "json",
 	})
 
-	return 0
+	if !opts.skipOpenAPI {
+		r.checkContract(L, req, body)
+	}
+
+	L.Push(responseTable(L, r.response.Result()))
+	return 1
+}
+
+// checkContract validates the request and response against the OpenAPI
+// document, if any. Violations fail the test, or are reported as a warning
+// with RestOpenAPIWarnOnly.
+func (r *REST) checkContract(L *lua.LState, req *http.Request, body []byte) {
+	doc, err := r.openAPIDoc()
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+	if doc == nil {
+		return
+	}
+
+	violations := r.validateContract(L.Context(), doc, req, body, r.response)
+	if len(violations) == 0 {
+		return
+	}
+	if r.opts.openAPIWarnOnly {
+		Info(L.Context(), reporter.Info{
+			Type:     reporter.InfoTypeWarning,
+			Title:    fmt.Sprintf("OpenAPI violations (%d)", len(violations)),
+			Content:  strings.Join(violations, "\n"),
+			Language: "text",
+		})
+		return
+	}
+	L.RaiseError("OpenAPI violations:\n  %s", strings.Join(violations, "\n  "))
+}
+
+// responseTable converts the response to a table with the status, headers and
+// body. Multiple values for a header are joined with commas. The body is
+// decoded based on its content type, see decodeBody.
+func responseTable(L *lua.LState, resp *http.Response) *lua.LTable {
+	headers := L.NewTable()
+	for k, v := range resp.Header {
+		headers.RawSetString(k, lua.LString(strings.Join(v, ", ")))
+	}
+
+	ret := L.NewTable()
+	ret.RawSetString("status", lua.LNumber(resp.StatusCode))
+	ret.RawSetString("headers", headers)
+
+	body, _ := io.ReadAll(resp.Body)
+	decoded, err := decodeBody(resp.Header.Get("Content-Type"), body)
+	if err != nil {
+		decoded = string(body)
+	}
+	if decoded != nil {
+		ret.RawSetString("body", ToLuaValue(decoded))
+	}
+	return ret
 }
 
 func (r *REST) addHeader(L *lua.LState) int {
@@ -166,7 +542,7 @@ func (r *REST) AfterTest(ctx context.Context) {
 
 func (r *REST) check(L *lua.LState) int {
 	code := L.CheckInt(1)
-	tbl := L.CheckTable(2)
+	tbl := CheckExpected(L, 2)
 
 	if r.response == nil {
 		L.RaiseError("send not called")
@@ -178,9 +554,9 @@ func (r *REST) check(L *lua.LState) int {
 		return 0
 	}
 
-	var res map[string]any
-	if err := json.Unmarshal(r.response.Body.Bytes(), &res); err != nil {
-		L.RaiseError("unable to unmarshal response: %v", err)
+	res, err := decodeBody(r.response.Header().Get("Content-Type"), r.response.Body.Bytes())
+	if err != nil {
+		L.RaiseError("unable to decode response: %v", err)
 		return 0
 	}
 
@@ -188,6 +564,47 @@ func (r *REST) check(L *lua.LState) int {
 	return 0
 }
 
+// checkHeaders checks the headers given in the expected table. Header names
+// are case insensitive, and multiple values are joined with commas.
+func (r *REST) checkHeaders(L *lua.LState) int {
+	tbl := L.CheckTable(1)
+	if r.response == nil {
+		L.RaiseError("send not called")
+		return 0
+	}
+
+	expected := L.NewTable()
+	tbl.ForEach(func(k, v lua.LValue) {
+		expected.RawSetString(http.CanonicalHeaderKey(lua.LVAsString(k)), v)
+	})
+
+	actual := map[string]any{}
+	for k, v := range r.response.Header() {
+		actual[k] = strings.Join(v, ", ")
+	}
+
+	ud := L.NewUserData()
+	ud.Value = spec.PartialData{Fields: expected}
+	StdCheck(L, ud, actual)
+	return 0
+}
+
+// checkBody checks the raw body of the response, either against a string or
+// a matcher
+func (r *REST) checkBody(L *lua.LState) int {
+	expected := L.CheckAny(1)
+	if expected.Type() != lua.LTString && expected.Type() != lua.LTUserData {
+		L.TypeError(1, lua.LTString)
+	}
+	if r.response == nil {
+		L.RaiseError("send not called")
+		return 0
+	}
+
+	StdCheck(L, expected, r.response.Body.String())
+	return 0
+}
+
 // func (s *REST) Run(ctx context.Context, logf func(format string, args ...any), body []byte, state map[string]any) error {
 // 	f, err := parser.Parse(body, state)
 // 	if err != nil {
@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// RestCoverage records which operations of the OpenAPI document are called
+// by one or more REST runners. Runners record coverage when created with
+// RestRecordCoverage, and only when they have an OpenAPI document. It's safe
+// for concurrent use.
+type RestCoverage struct {
+	mu  sync.Mutex
+	doc *openapi3.T
+	// hits is keyed by path, then method
+	hits map[string]map[string]int
+}
+
+func NewRestCoverage() *RestCoverage {
+	return &RestCoverage{hits: map[string]map[string]int{}}
+}
+
+// RestRecordCoverage records the operation of every request in coverage
+func RestRecordCoverage(coverage *RestCoverage) RestOption {
+	return func(o *restOptions) {
+		o.coverage = coverage
+	}
+}
+
+// RestCoverageReport is the coverage of the operations in the OpenAPI
+// document
+type RestCoverageReport struct {
+	Covered    int                     `json:"covered"`
+	Total      int                     `json:"total"`
+	Operations []RestOperationCoverage `json:"operations"`
+	// Uncovered lists the operations that were never called, as METHOD path
+	Uncovered []string `json:"uncovered"`
+}
+
+type RestOperationCoverage struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	OperationID string `json:"operationId,omitempty"`
+	Hits        int    `json:"hits"`
+}
+
+func (c *RestCoverage) record(doc *openapi3.T, method, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.doc == nil {
+		c.doc = doc
+	}
+
+	methods, ok := c.hits[path]
+	if !ok {
+		methods = map[string]int{}
+		c.hits[path] = methods
+	}
+	methods[method]++
+}
+
+// Report returns the coverage so far. The report is empty until a request
+// has been recorded.
+func (c *RestCoverage) Report() *RestCoverageReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := &RestCoverageReport{Operations: []RestOperationCoverage{}, Uncovered: []string{}}
+	if c.doc == nil {
+		return report
+	}
+
+	paths := c.doc.Paths.Map()
+	for _, path := range slices.Sorted(maps.Keys(paths)) {
+		ops := paths[path].Operations()
+		for _, method := range slices.Sorted(maps.Keys(ops)) {
+			hits := c.hits[path][method]
+			report.Operations = append(report.Operations, RestOperationCoverage{
+				Method:      method,
+				Path:        path,
+				OperationID: ops[method].OperationID,
+				Hits:        hits,
+			})
+			report.Total++
+			if hits > 0 {
+				report.Covered++
+			} else {
+				report.Uncovered = append(report.Uncovered, method+" "+path)
+			}
+		}
+	}
+
+	return report
+}
+
+// WriteJSON writes the report as indented JSON
+func (c *RestCoverage) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c.Report())
+}
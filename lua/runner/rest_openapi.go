@@ -0,0 +1,230 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+)
+
+type RestOption func(*restOptions)
+
+type restOptions struct {
+	openAPI         []byte
+	openAPIWarnOnly bool
+	coverage        *RestCoverage
+}
+
+// RestOpenAPI validates every request and response against the OpenAPI 3
+// document, given as JSON or YAML. Violations fail the test, unless
+// RestOpenAPIWarnOnly is used.
+func RestOpenAPI(doc []byte) RestOption {
+	return func(o *restOptions) {
+		o.openAPI = doc
+	}
+}
+
+// RestOpenAPIWarnOnly reports OpenAPI violations as warnings instead of
+// failing the test
+func RestOpenAPIWarnOnly() RestOption {
+	return func(o *restOptions) {
+		o.openAPIWarnOnly = true
+	}
+}
+
+// openAPIDoc loads the OpenAPI document once. It returns nil if the runner
+// has no document.
+func (r *REST) openAPIDoc() (*openapi3.T, error) {
+	r.docOnce.Do(func() {
+		if len(r.opts.openAPI) == 0 {
+			return
+		}
+		loader := openapi3.NewLoader()
+		doc, err := loader.LoadFromData(r.opts.openAPI)
+		if err != nil {
+			r.docErr = fmt.Errorf("unable to load OpenAPI document: %w", err)
+			return
+		}
+		if err := doc.Validate(loader.Context); err != nil {
+			r.docErr = fmt.Errorf("invalid OpenAPI document: %w", err)
+			return
+		}
+		r.loadedDoc = doc
+	})
+	return r.loadedDoc, r.docErr
+}
+
+// validateContract validates the request and response against the OpenAPI
+// document, and returns the violations. The operation is recorded in the
+// coverage, if any.
+func (r *REST) validateContract(ctx context.Context, doc *openapi3.T, req *http.Request, body []byte, rec *httptest.ResponseRecorder) []string {
+	route, params, err := findOpenAPIRoute(doc, req.Method, req.URL.Path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if r.opts.coverage != nil {
+		r.opts.coverage.record(doc, route.Method, route.Path)
+	}
+
+	opts := &openapi3filter.Options{
+		MultiError:            true,
+		IncludeResponseStatus: true,
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		SkipSettingDefaults:   true,
+	}
+
+	// The request body has been read by the handler
+	validationReq := req.Clone(ctx)
+	validationReq.Body = io.NopCloser(bytes.NewReader(body))
+
+	reqInput := &openapi3filter.RequestValidationInput{
+		Request:    validationReq,
+		PathParams: params,
+		Route:      route,
+		Options:    opts,
+	}
+
+	var violations []string
+	if err := openapi3filter.ValidateRequest(ctx, reqInput); err != nil {
+		violations = append(violations, formatOpenAPIErrors("request", err)...)
+	}
+
+	respInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: reqInput,
+		Status:                 rec.Code,
+		Header:                 rec.Header(),
+		Options:                opts,
+	}
+	respInput.SetBodyBytes(rec.Body.Bytes())
+	if err := openapi3filter.ValidateResponse(ctx, respInput); err != nil {
+		violations = append(violations, formatOpenAPIErrors("response", err)...)
+	}
+	return violations
+}
+
+// formatOpenAPIErrors flattens the validation errors to one line each,
+// prefixed with what was validated
+func formatOpenAPIErrors(prefix string, err error) []string {
+	switch err := err.(type) {
+	case openapi3.MultiError:
+		var ret []string
+		for _, e := range err {
+			ret = append(ret, formatOpenAPIErrors(prefix, e)...)
+		}
+		return ret
+	case *openapi3filter.RequestError:
+		switch {
+		case err.Parameter != nil:
+			prefix += fmt.Sprintf(" parameter %q in %s", err.Parameter.Name, err.Parameter.In)
+		case err.RequestBody != nil:
+			prefix += " body"
+		}
+		if err.Err == nil {
+			return []string{prefix + ": " + err.Reason}
+		}
+		if err.Reason != "" && err.Reason != "doesn't match schema" {
+			prefix += ": " + err.Reason
+		}
+		return formatOpenAPIErrors(prefix, err.Err)
+	case *openapi3filter.ResponseError:
+		if err.Err == nil {
+			return []string{prefix + ": " + err.Reason}
+		}
+		return formatOpenAPIErrors(prefix+": "+err.Reason, err.Err)
+	case *openapi3.SchemaError:
+		if ptr := err.JSONPointer(); len(ptr) > 0 {
+			return []string{fmt.Sprintf("%s: %s at /%s", prefix, err.Reason, strings.Join(ptr, "/"))}
+		}
+		return []string{prefix + ": " + err.Reason}
+	default:
+		return []string{prefix + ": " + err.Error()}
+	}
+}
+
+// findOpenAPIRoute finds the operation for the request. Paths are matched
+// both as is, and without the path of each server in the document. Literal
+// path segments take precedence over templated ones.
+func findOpenAPIRoute(doc *openapi3.T, method, reqPath string) (*routers.Route, map[string]string, error) {
+	candidates := []string{reqPath}
+	for _, s := range doc.Servers {
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			continue
+		}
+		prefix := strings.TrimSuffix(u.Path, "/")
+		if prefix != "" && strings.HasPrefix(reqPath, prefix+"/") {
+			candidates = append(candidates, strings.TrimPrefix(reqPath, prefix))
+		}
+	}
+
+	var (
+		best        string
+		bestParams  map[string]string
+		bestLiteral = -1
+	)
+	for _, candidate := range candidates {
+		for _, tmpl := range doc.Paths.InMatchingOrder() {
+			params, literal, ok := matchOpenAPIPath(tmpl, candidate)
+			if ok && literal > bestLiteral {
+				best, bestParams, bestLiteral = tmpl, params, literal
+			}
+		}
+	}
+	if best == "" {
+		return nil, nil, fmt.Errorf("%s %s is not in the OpenAPI document", method, reqPath)
+	}
+
+	item := doc.Paths.Value(best)
+	op := item.GetOperation(method)
+	if op == nil {
+		return nil, nil, fmt.Errorf("%s %s is not in the OpenAPI document, the path %s only has %s", method, reqPath, best, strings.Join(slices.Sorted(maps.Keys(item.Operations())), ", "))
+	}
+	return &routers.Route{
+		Spec:      doc,
+		Path:      best,
+		PathItem:  item,
+		Method:    method,
+		Operation: op,
+	}, bestParams, nil
+}
+
+// matchOpenAPIPath matches the path against a path template, and returns the
+// path parameters and the number of literal segments
+func matchOpenAPIPath(tmpl, reqPath string) (map[string]string, int, bool) {
+	tmplParts := strings.Split(strings.Trim(tmpl, "/"), "/")
+	pathParts := strings.Split(strings.Trim(reqPath, "/"), "/")
+	if len(tmplParts) != len(pathParts) {
+		return nil, 0, false
+	}
+
+	params := map[string]string{}
+	literal := 0
+	for i, part := range tmplParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if pathParts[i] == "" {
+				return nil, 0, false
+			}
+			value, err := url.PathUnescape(pathParts[i])
+			if err != nil {
+				value = pathParts[i]
+			}
+			params[strings.Trim(part, "{}")] = value
+			continue
+		}
+		if part != pathParts[i] {
+			return nil, 0, false
+		}
+		literal++
+	}
+	return params, literal, true
+}
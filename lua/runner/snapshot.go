@@ -0,0 +1,341 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/nais/tester/lua/reporter"
+	"github.com/nais/tester/lua/spec"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// SnapshotStore holds the snapshots of a single Lua file. The snapshots are
+// stored as a JSON object keyed by snapshot name in a file next to the Lua
+// file, e.g. users.lua stores its snapshots in users.snapshots.json.
+type SnapshotStore struct {
+	path   string
+	update bool
+
+	lock   sync.Mutex
+	loaded bool
+	dirty  bool
+	values map[string]any
+}
+
+// NewSnapshotStore creates a store for the snapshots of luaFile. If update is
+// true, existing snapshots are overwritten with the actual values.
+func NewSnapshotStore(luaFile string, update bool) *SnapshotStore {
+	return &SnapshotStore{
+		path:   strings.TrimSuffix(luaFile, filepath.Ext(luaFile)) + ".snapshots.json",
+		update: update,
+	}
+}
+
+func WithSnapshots(ctx context.Context, store *SnapshotStore) context.Context {
+	return context.WithValue(ctx, ctxSnapshots, store)
+}
+
+func getSnapshots(ctx context.Context) *SnapshotStore {
+	s, _ := ctx.Value(ctxSnapshots).(*SnapshotStore)
+	return s
+}
+
+// Path returns the path of the snapshot file
+func (s *SnapshotStore) Path() string {
+	return s.path
+}
+
+// Get returns the snapshot with the given name. The boolean is false if the
+// snapshot doesn't exist.
+func (s *SnapshotStore) Get(name string) (any, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, false, err
+	}
+	v, ok := s.values[name]
+	return v, ok, nil
+}
+
+// Set stores v as the snapshot with the given name. The value is normalized
+// through JSON, and the normalized value is returned.
+func (s *SnapshotStore) Set(name string, v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode snapshot %q: %w", name, err)
+	}
+	var normalized any
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return nil, fmt.Errorf("unable to decode snapshot %q: %w", name, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.values[name] = normalized
+	s.dirty = true
+	return normalized, nil
+}
+
+// Save writes the snapshots to disk if any snapshot has been written.
+func (s *SnapshotStore) Save() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.values, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode snapshots: %w", err)
+	}
+	if err := os.WriteFile(s.path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("unable to write snapshots: %w", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+func (s *SnapshotStore) load() error {
+	if s.loaded {
+		return nil
+	}
+
+	s.values = map[string]any{}
+	b, err := os.ReadFile(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to read snapshots: %w", err)
+	}
+	if len(bytes.TrimSpace(b)) > 0 {
+		if err := json.Unmarshal(b, &s.values); err != nil {
+			return fmt.Errorf("unable to parse snapshots in %s: %w", s.path, err)
+		}
+	}
+	s.loaded = true
+	return nil
+}
+
+// resolveSnapshots replaces every MatchSnapshot in expected with the stored
+// snapshot, merged with the overrides given to MatchSnapshot. Missing snapshots,
+// or all snapshots when updating, are written using the actual value.
+// Tables and matchers containing a snapshot are copied, the original is never
+// changed. Snapshots in the elements of Unordered and ContainsElements are
+// written using the actual element at the same position.
+func resolveSnapshots(ctx context.Context, expected lua.LValue, actual any) (lua.LValue, bool, error) {
+	switch v := expected.(type) {
+	case *lua.LUserData:
+		var (
+			tbl  *lua.LTable
+			wrap func(*lua.LTable) any
+		)
+		switch data := v.Value.(type) {
+		case spec.SnapshotData:
+			ret, err := matchSnapshot(ctx, data, actual)
+			return ret, true, err
+		case spec.PartialData:
+			tbl, wrap = data.Fields, func(t *lua.LTable) any { return spec.PartialData{Fields: t} }
+		case spec.UnorderedData:
+			tbl, wrap = data.Elements, func(t *lua.LTable) any { return spec.UnorderedData{Elements: t} }
+		case spec.ContainsElementsData:
+			tbl, wrap = data.Elements, func(t *lua.LTable) any { return spec.ContainsElementsData{Elements: t} }
+		default:
+			return expected, false, nil
+		}
+		resolved, changed, err := resolveTable(ctx, tbl, actual)
+		if err != nil || !changed {
+			return expected, false, err
+		}
+		return &lua.LUserData{Value: wrap(resolved), Metatable: v.Metatable}, true, nil
+	case *lua.LTable:
+		return resolveTable(ctx, v, actual)
+	}
+	return expected, false, nil
+}
+
+// resolveTable resolves the snapshots in the values of tbl against the
+// matching values of actual. The table is returned as is if it has no
+// snapshots.
+func resolveTable(ctx context.Context, tbl *lua.LTable, actual any) (*lua.LTable, bool, error) {
+	ret := newTable()
+	changed := false
+	var err error
+	tbl.ForEach(func(k, val lua.LValue) {
+		if err != nil {
+			return
+		}
+		var (
+			resolved lua.LValue
+			c        bool
+		)
+		resolved, c, err = resolveSnapshots(ctx, val, childValue(actual, k))
+		changed = changed || c
+		ret.RawSet(k, resolved)
+	})
+	if err != nil || !changed {
+		return tbl, false, err
+	}
+	return ret, true, nil
+}
+
+// hasSnapshots returns whether expected contains a MatchSnapshot matcher,
+// including in the fields and elements of other matchers
+func hasSnapshots(expected lua.LValue) bool {
+	switch v := expected.(type) {
+	case *lua.LUserData:
+		switch data := v.Value.(type) {
+		case spec.SnapshotData:
+			return true
+		case spec.PartialData:
+			return hasSnapshots(data.Fields)
+		case spec.UnorderedData:
+			return hasSnapshots(data.Elements)
+		case spec.ContainsElementsData:
+			return hasSnapshots(data.Elements)
+		}
+	case *lua.LTable:
+		found := false
+		v.ForEach(func(_, val lua.LValue) {
+			found = found || hasSnapshots(val)
+		})
+		return found
+	}
+	return false
+}
+
+func matchSnapshot(ctx context.Context, sd spec.SnapshotData, actual any) (lua.LValue, error) {
+	store := getSnapshots(ctx)
+	if store == nil {
+		return nil, fmt.Errorf("snapshot %q: snapshots are not available here", sd.Name)
+	}
+
+	expected, ok, err := store.Get(sd.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok || store.update {
+		expected, err = store.Set(sd.Name, actual)
+		if err != nil {
+			return nil, err
+		}
+		content, _ := json.MarshalIndent(expected, "", "  ")
+		Info(ctx, reporter.Info{
+			Type:    reporter.InfoTypeSnapshot,
+			Title:   "Snapshot written",
+			Content: string(content),
+			Args: []reporter.InfoArg{
+				{Name: "name", Value: sd.Name},
+				{Name: "file", Value: filepath.Base(store.Path())},
+			},
+		})
+	}
+
+	ret := jsonToLua(expected)
+	if sd.Overrides != nil {
+		ret = mergeOverrides(ret, sd.Overrides)
+	}
+	return ret, nil
+}
+
+// mergeOverrides merges overrides into base, which must not be shared with
+// Lua code. Tables are merged recursively, other values replace the value in
+// base. If base is a list and overrides is a list with a single element, the
+// element is merged into every element of base.
+func mergeOverrides(base lua.LValue, overrides *lua.LTable) lua.LValue {
+	tbl, ok := base.(*lua.LTable)
+	if !ok {
+		return overrides
+	}
+
+	if overrides.Len() == 1 && tbl.Len() > 1 {
+		if o, ok := overrides.RawGetInt(1).(*lua.LTable); ok {
+			for i := 1; i <= tbl.Len(); i++ {
+				tbl.RawSetInt(i, mergeOverrides(tbl.RawGetInt(i), o))
+			}
+			return tbl
+		}
+	}
+
+	overrides.ForEach(func(k, v lua.LValue) {
+		if o, ok := v.(*lua.LTable); ok {
+			tbl.RawSet(k, mergeOverrides(tbl.RawGet(k), o))
+			return
+		}
+		tbl.RawSet(k, v)
+	})
+	return tbl
+}
+
+// jsonToLua converts a value decoded from JSON to a Lua value suitable for
+// convertToCheck. JSON null is converted to the Null matcher, so the key is
+// kept when comparing.
+func jsonToLua(v any) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return &lua.LUserData{Value: spec.Null{}, Metatable: lua.LNil}
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []any:
+		tbl := newTable()
+		for _, e := range v {
+			tbl.Append(jsonToLua(e))
+		}
+		return tbl
+	case map[string]any:
+		tbl := newTable()
+		for k, e := range v {
+			tbl.RawSetString(k, jsonToLua(e))
+		}
+		return tbl
+	}
+	return lua.LString(fmt.Sprint(v))
+}
+
+// childValue returns the value of key k in v, where v is a map with string
+// keys, or a slice indexed using Lua's 1-based indexes. It returns nil if v
+// doesn't contain the key.
+func childValue(v any, k lua.LValue) any {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		e := rv.MapIndex(reflect.ValueOf(lua.LVAsString(k)).Convert(rv.Type().Key()))
+		if e.IsValid() {
+			return e.Interface()
+		}
+	case reflect.Slice, reflect.Array:
+		n, ok := k.(lua.LNumber)
+		if !ok {
+			return nil
+		}
+		idx := int(n) - 1
+		if idx >= 0 && idx < rv.Len() {
+			return rv.Index(idx).Interface()
+		}
+	}
+	return nil
+}
+
+// newTable creates a table without a Lua state
+func newTable() *lua.LTable {
+	return &lua.LTable{Metatable: lua.LNil}
+}
@@ -72,8 +72,9 @@ func (s *SQL) Functions() []*spec.Function {
 					Doc:  "The query arguments",
 				},
 			},
-			Doc:  "Query for multiple rows",
-			Func: s.query,
+			Doc:     "Query for multiple rows, and return the rows",
+			Func:    s.query,
+			Returns: []spec.ArgumentType{spec.ArgumentTypeArray{Type: spec.ArgumentTypeTable}},
 		},
 		{
 			Name: "queryRow",
@@ -89,15 +90,16 @@ func (s *SQL) Functions() []*spec.Function {
 					Doc:  "The query arguments",
 				},
 			},
-			Doc:  "Query for a single row. Will error if no rows returned",
-			Func: s.queryRow,
+			Doc:     "Query for a single row, and return the row. Will error if no rows returned",
+			Func:    s.queryRow,
+			Returns: []spec.ArgumentType{spec.ArgumentTypeTable},
 		},
 		StdCheckDefinition(s.check),
 	}
 }
 
 func (s *SQL) check(L *lua.LState) int {
-	tbl := L.CheckTable(1)
+	tbl := CheckExpected(L, 1)
 
 	StdCheck(L, tbl, s.results)
 	return 0
@@ -146,7 +148,8 @@ func (s *SQL) query(L *lua.LState) int {
 		Language: "json",
 	})
 
-	return 0
+	L.Push(ToLuaValue(sqlValues(s.results)))
+	return 1
 }
 
 func (s *SQL) queryRow(L *lua.LState) int {
@@ -171,7 +174,30 @@ func (s *SQL) queryRow(L *lua.LState) int {
 		Language: "json",
 	})
 
-	return 0
+	L.Push(ToLuaValue(sqlValues(s.results)))
+	return 1
+}
+
+// sqlValues converts UUIDs in rows, which are returned as byte arrays, to
+// strings before they are converted to Lua.
+func sqlValues(v any) any {
+	switch v := v.(type) {
+	case []any:
+		ret := make([]any, len(v))
+		for i, e := range v {
+			ret[i] = sqlValues(e)
+		}
+		return ret
+	case map[string]any:
+		ret := make(map[string]any, len(v))
+		for k, e := range v {
+			ret[k] = sqlValues(e)
+		}
+		return ret
+	case [16]byte:
+		return fmt.Sprintf("%x-%x-%x-%x-%x", v[0:4], v[4:6], v[6:8], v[8:10], v[10:16])
+	}
+	return v
 }
 
 func (s *SQL) queryHelper(L *lua.LState) int {
@@ -0,0 +1,50 @@
+package runner
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ToLuaValue converts a Go value, e.g. a decoded JSON response, to Lua. Maps
+// with string keys and slices are converted to tables, and values which are
+// not numbers, strings or booleans are converted to strings.
+func ToLuaValue(v any) lua.LValue {
+	if v == nil {
+		return lua.LNil
+	}
+	if n, ok := toFloat(v); ok {
+		return lua.LNumber(n)
+	}
+
+	switch v := v.(type) {
+	case string:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	case time.Time:
+		return lua.LString(v.Format(time.RFC3339Nano))
+	case fmt.Stringer:
+		return lua.LString(v.String())
+	}
+
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+		tbl := newTable()
+		iter := rv.MapRange()
+		for iter.Next() {
+			tbl.RawSetString(iter.Key().String(), ToLuaValue(iter.Value().Interface()))
+		}
+		return tbl
+	case rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array:
+		tbl := newTable()
+		for i := range rv.Len() {
+			tbl.RawSetInt(i+1, ToLuaValue(rv.Index(i).Interface()))
+		}
+		return tbl
+	}
+	return lua.LString(fmt.Sprint(v))
+}
@@ -1,6 +1,12 @@
 package spec
 
 import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
 	lua "github.com/yuin/gopher-lua"
 )
 
@@ -67,3 +73,307 @@ func Contains(L *lua.LState) int {
 
 	return 1
 }
+
+type SnapshotData struct {
+	Name      string
+	Overrides *lua.LTable
+}
+
+func MatchSnapshot(L *lua.LState) int {
+	name := L.CheckString(1)
+	overrides := L.OptTable(2, nil)
+
+	ud := L.NewUserData()
+	ud.Value = SnapshotData{
+		Name:      name,
+		Overrides: overrides,
+	}
+
+	L.Push(ud)
+
+	return 1
+}
+
+type MatchesData struct {
+	Regexp *regexp.Regexp
+}
+
+func Matches(L *lua.LState) int {
+	expr := L.CheckString(1)
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		L.ArgError(1, "invalid regular expression: "+err.Error())
+		return 0
+	}
+
+	ud := L.NewUserData()
+	ud.Value = MatchesData{Regexp: re}
+
+	L.Push(ud)
+
+	return 1
+}
+
+type BetweenData struct {
+	Min, Max float64
+}
+
+func Between(L *lua.LState) int {
+	minValue := float64(L.CheckNumber(1))
+	maxValue := float64(L.CheckNumber(2))
+	if minValue > maxValue {
+		L.ArgError(2, "max must be greater than or equal to min")
+		return 0
+	}
+
+	ud := L.NewUserData()
+	ud.Value = BetweenData{Min: minValue, Max: maxValue}
+
+	L.Push(ud)
+
+	return 1
+}
+
+type GreaterThanData struct {
+	Value float64
+}
+
+func GreaterThan(L *lua.LState) int {
+	ud := L.NewUserData()
+	ud.Value = GreaterThanData{Value: float64(L.CheckNumber(1))}
+
+	L.Push(ud)
+
+	return 1
+}
+
+type LessThanData struct {
+	Value float64
+}
+
+func LessThan(L *lua.LState) int {
+	ud := L.NewUserData()
+	ud.Value = LessThanData{Value: float64(L.CheckNumber(1))}
+
+	L.Push(ud)
+
+	return 1
+}
+
+// MatcherTypes are the types accepted by IsType
+var MatcherTypes = []string{"string", "number", "boolean", "table", "list", "map", "null"}
+
+type IsTypeData struct {
+	Type string
+}
+
+func IsType(L *lua.LState) int {
+	typ := L.CheckString(1)
+	if !slices.Contains(MatcherTypes, typ) {
+		L.ArgError(1, "type must be one of "+strings.Join(MatcherTypes, ", "))
+		return 0
+	}
+
+	ud := L.NewUserData()
+	ud.Value = IsTypeData{Type: typ}
+
+	L.Push(ud)
+
+	return 1
+}
+
+type OneOfData struct {
+	Values []any
+}
+
+func OneOf(L *lua.LState) int {
+	if L.GetTop() == 0 {
+		L.ArgError(1, "at least one value is required")
+		return 0
+	}
+
+	values := make([]any, 0, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString:
+			values = append(values, string(v))
+		case lua.LNumber:
+			values = append(values, float64(v))
+		case lua.LBool:
+			values = append(values, bool(v))
+		default:
+			L.ArgError(i, "must be a string, number or boolean")
+			return 0
+		}
+	}
+
+	ud := L.NewUserData()
+	ud.Value = OneOfData{Values: values}
+
+	L.Push(ud)
+
+	return 1
+}
+
+type ISODateData struct{}
+
+func ISODate(L *lua.LState) int {
+	ud := L.NewUserData()
+	ud.Value = ISODateData{}
+
+	L.Push(ud)
+
+	return 1
+}
+
+type UnorderedData struct {
+	Elements *lua.LTable
+}
+
+func Unordered(L *lua.LState) int {
+	ud := L.NewUserData()
+	ud.Value = UnorderedData{Elements: L.CheckTable(1)}
+
+	L.Push(ud)
+
+	return 1
+}
+
+type ContainsElementsData struct {
+	Elements *lua.LTable
+}
+
+func ContainsElements(L *lua.LState) int {
+	ud := L.NewUserData()
+	ud.Value = ContainsElementsData{Elements: L.CheckTable(1)}
+
+	L.Push(ud)
+
+	return 1
+}
+
+type PartialData struct {
+	Fields *lua.LTable
+}
+
+func Partial(L *lua.LState) int {
+	ud := L.NewUserData()
+	ud.Value = PartialData{Fields: L.CheckTable(1)}
+
+	L.Push(ud)
+
+	return 1
+}
+
+type LengthData struct {
+	Length int
+}
+
+func Length(L *lua.LState) int {
+	n := L.CheckInt(1)
+	if n < 0 {
+		L.ArgError(1, "length must not be negative")
+		return 0
+	}
+
+	ud := L.NewUserData()
+	ud.Value = LengthData{Length: n}
+
+	L.Push(ud)
+
+	return 1
+}
+
+type MatcherData struct {
+	Matcher Matcher
+	Args    []any
+}
+
+// NewMatcherFunc returns the Lua function creating matchers for m
+func NewMatcherFunc(m Matcher) lua.LGFunction {
+	return func(L *lua.LState) int {
+		args := make([]any, 0, L.GetTop())
+		for i := 1; i <= L.GetTop(); i++ {
+			arg, err := ToGoValue(L.Get(i))
+			if err != nil {
+				L.ArgError(i, err.Error())
+			}
+			args = append(args, arg)
+		}
+
+		ud := L.NewUserData()
+		ud.Value = MatcherData{Matcher: m, Args: args}
+
+		L.Push(ud)
+
+		return 1
+	}
+}
+
+type SatisfiesData struct {
+	L  *lua.LState
+	Fn *lua.LFunction
+}
+
+func Satisfies(L *lua.LState) int {
+	ud := L.NewUserData()
+	ud.Value = SatisfiesData{L: L, Fn: L.CheckFunction(1)}
+
+	L.Push(ud)
+
+	return 1
+}
+
+// ToGoValue converts a Lua value to a Go value, which can be encoded as
+// JSON. Tables with list elements are converted to lists, and other tables to
+// maps. An empty table has no list elements, so it's converted to an empty map
+// and encoded as {}, not []. Tables with both list elements and other keys
+// are rejected. Null is converted to nil, and other userdata to its value.
+func ToGoValue(v lua.LValue) (any, error) {
+	switch v := v.(type) {
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber:
+		return float64(v), nil
+	case lua.LString:
+		return string(v), nil
+	case *lua.LUserData:
+		if _, ok := v.Value.(Null); ok {
+			return nil, nil
+		}
+		return v.Value, nil
+	case *lua.LTable:
+		var err error
+		if v.Len() == 0 {
+			m := map[string]any{}
+			v.ForEach(func(k, val lua.LValue) {
+				if err == nil {
+					m[lua.LVAsString(k)], err = ToGoValue(val)
+				}
+			})
+			return m, err
+		}
+		l := make([]any, 0, v.Len())
+		v.ForEach(func(k, val lua.LValue) {
+			if err != nil {
+				return
+			}
+			if n, ok := k.(lua.LNumber); !ok || int(n) != len(l)+1 {
+				err = fmt.Errorf("table has both list elements and the key %s", formatKey(k))
+				return
+			}
+			var e any
+			e, err = ToGoValue(val)
+			l = append(l, e)
+		})
+		return l, err
+	}
+	return nil, nil
+}
+
+func formatKey(k lua.LValue) string {
+	if s, ok := k.(lua.LString); ok {
+		return strconv.Quote(string(s))
+	}
+	return k.String()
+}
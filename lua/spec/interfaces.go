@@ -18,10 +18,30 @@ type HasHelperFunctions interface {
 	HelperFunctions() []*Function
 }
 
+// HasTypeAnnotations is implemented by runners with extra Lua type
+// annotations for the generated spec, such as types from a schema.
+type HasTypeAnnotations interface {
+	TypeAnnotations() (string, error)
+}
+
 type RunnerAfterTest interface {
 	AfterTest(ctx context.Context)
 }
 
+// Matcher is a custom matcher for check tables, available in Lua as a global
+// function named after the matcher. The function takes the arguments
+// described by Args, and returns a value to use in place of the expected
+// value.
+type Matcher interface {
+	Name() string
+	Doc() string
+	Args() []Argument
+	// Match reports whether the actual value matches, given the arguments
+	// passed to the Lua function. Numbers are passed as float64, and tables
+	// as map[string]any or []any.
+	Match(args []any, actual any) bool
+}
+
 type StringEnum []string
 
 func (e StringEnum) String() string {
@@ -48,6 +68,10 @@ func (a ArgumentTypeA) String() string {
 		return "boolean"
 	case ArgumentTypeTable:
 		return "table"
+	case ArgumentTypeUserData:
+		return "userdata"
+	case ArgumentTypeAny:
+		return "any"
 	default:
 		panic(fmt.Sprintf("unknown type: %d", a))
 	}
@@ -58,6 +82,8 @@ const (
 	ArgumentTypeNumber
 	ArgumentTypeBoolean
 	ArgumentTypeTable
+	ArgumentTypeUserData
+	ArgumentTypeAny
 )
 
 type ArgumentTypeMetatable string
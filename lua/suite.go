@@ -1,15 +1,21 @@
 package lua
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/go-viper/mapstructure/v2"
 	"github.com/nais/tester/lua/reporter"
 	"github.com/nais/tester/lua/runner"
 	"github.com/nais/tester/lua/spec"
 	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
 )
 
 // testReporter is a wrapper that holds the current test's reporter
@@ -17,6 +23,38 @@ type testReporter struct {
 	reporter reporter.Reporter
 }
 
+type testMode int
+
+const (
+	testModeDefault testMode = iota
+	// testModeSkip is used for tests declared with Test.skip.<runner>
+	testModeSkip
+	// testModeOnly is used for tests declared with Test.only.<runner>
+	testModeOnly
+)
+
+// usesOnly returns whether the source uses Test.only. It's used to find out
+// if a file focuses tests before it's executed, so tests declared before the
+// focused test can be skipped as well. The source is tokenized, so Test.only
+// in comments and strings is ignored.
+func usesOnly(src []byte, filename string) bool {
+	sc := parse.NewScanner(bytes.NewReader(src), filename)
+	lexer := &parse.Lexer{}
+	var prev [2]ast.Token
+	for {
+		tok, err := sc.Scan(lexer)
+		if err != nil || tok.Type == parse.EOF {
+			return false
+		}
+		if tok.Type == parse.TIdent && tok.Str == "only" &&
+			prev[1].Type == '.' && prev[0].Type == parse.TIdent && prev[0].Str == "Test" {
+			return true
+		}
+		lexer.PrevTokenType = tok.Type
+		prev[0], prev[1] = prev[1], tok
+	}
+}
+
 type suite struct {
 	// setupDone should be set to true after the setup function has been called. This should
 	// be done when the first test is run or first helper is invoked.
@@ -27,6 +65,11 @@ type suite struct {
 	reporter  reporter.Reporter
 	cfg       any
 	cleanup   func()
+	// hasOnly is true if any test in the file is declared with Test.only
+	hasOnly bool
+	hooks   hooks
+	// updateSnapshots overwrites existing snapshots with the actual values
+	updateSnapshots bool
 }
 
 func newSuite(mgr *Manager, reporter reporter.Reporter) *suite {
@@ -53,12 +96,36 @@ func (s *suite) run(ctx context.Context, filename string) {
 
 	// Set file-level reporter in context so top-level calls can log info
 	ctx = runner.WithReporter(ctx, s.reporter)
+	snapshots := runner.NewSnapshotStore(filename, s.updateSnapshots)
+	ctx = runner.WithSnapshots(ctx, snapshots)
+	ctx = runner.WithFilename(ctx, filename)
+	if s.mgr.cookieJar {
+		ctx = runner.WithCookieJar(ctx, runner.NewCookieJar())
+	}
 	L.SetContext(ctx)
 
 	L.Register("Save", spec.Save)
 	L.Register("Ignore", spec.Ignore)
 	L.Register("NotNull", spec.NotNull)
 	L.Register("Contains", spec.Contains)
+	L.Register("MatchSnapshot", spec.MatchSnapshot)
+	L.Register("Matches", spec.Matches)
+	L.Register("Between", spec.Between)
+	L.Register("GreaterThan", spec.GreaterThan)
+	L.Register("LessThan", spec.LessThan)
+	L.Register("IsType", spec.IsType)
+	L.Register("OneOf", spec.OneOf)
+	L.Register("ISODate", spec.ISODate)
+	L.Register("Unordered", spec.Unordered)
+	L.Register("ContainsElements", spec.ContainsElements)
+	L.Register("Partial", spec.Partial)
+	L.Register("Length", spec.Length)
+	L.Register("Satisfies", spec.Satisfies)
+	for _, m := range s.mgr.matchers {
+		L.Register(m.Name(), spec.NewMatcherFunc(m))
+	}
+	L.Register("Eventually", eventually)
+	s.registerHooks(L)
 
 	nullD := L.NewUserData()
 	nullD.Value = spec.Null{}
@@ -71,12 +138,19 @@ func (s *suite) run(ctx context.Context, filename string) {
 	L.SetGlobal("State", s.state)
 
 	tests := map[string]lua.LGFunction{}
+	skipTests := map[string]lua.LGFunction{}
+	onlyTests := map[string]lua.LGFunction{}
 
 	for _, r := range s.mgr.runners {
-		tests[r.Name()] = s.newTest(r.Name(), L)
+		tests[r.Name()] = s.newTest(r.Name(), testModeDefault)
+		skipTests[r.Name()] = s.newTest(r.Name(), testModeSkip)
+		onlyTests[r.Name()] = s.newTest(r.Name(), testModeOnly)
 	}
 
 	mod := L.SetFuncs(L.NewTable(), tests)
+	L.SetField(mod, "skip", L.SetFuncs(L.NewTable(), skipTests))
+	L.SetField(mod, "only", L.SetFuncs(L.NewTable(), onlyTests))
+	L.SetField(mod, "todo", L.NewFunction(s.todo))
 	L.SetGlobal("Test", mod)
 
 	helperFuncs := map[string]lua.LGFunction{}
@@ -153,18 +227,113 @@ func (s *suite) run(ctx context.Context, filename string) {
 
 	}
 
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		s.reporter.ReportError(reporter.NewError("unable to read file: %v", err))
+		return
+	}
+	s.hasOnly = usesOnly(src, filename)
+
 	if err := L.DoFile(filename); err != nil {
 		s.reporter.ReportError(reporter.NewError("%s", err.Error()))
 	}
+
+	s.runAfterAll(L)
+
+	if err := snapshots.Save(); err != nil {
+		s.reporter.ReportError(reporter.NewError("%s", err.Error()))
+	}
+}
+
+// testOptions can be given as an optional table between the name and the
+// function of a test, e.g. Test.gql("name", { timeout = "5s" }, function(t) end)
+type testOptions struct {
+	timeout time.Duration
+	// retries is the number of times a failing test is run again
+	retries int
+}
+
+// defaultTestOptions returns the options of tests without an options table
+func (s *suite) defaultTestOptions() testOptions {
+	return testOptions{timeout: s.mgr.testTimeout}
+}
+
+// parseTestOptions returns the default options, overridden by the options in
+// tbl
+func (s *suite) parseTestOptions(L *lua.LState, tbl *lua.LTable) testOptions {
+	opts := s.defaultTestOptions()
+
+	tbl.ForEach(func(k, v lua.LValue) {
+		switch key := lua.LVAsString(k); key {
+		case "timeout":
+			opts.timeout = luaDuration(L, key, v)
+		case "retry":
+			n, ok := v.(lua.LNumber)
+			if !ok || n < 0 || n != lua.LNumber(int(n)) {
+				L.RaiseError("retry must be a non-negative integer")
+			}
+			opts.retries = int(n)
+		default:
+			L.RaiseError("unknown test option %q", key)
+		}
+	})
+
+	return opts
+}
+
+// luaDuration converts a string such as "5s" or a number of seconds to a duration
+func luaDuration(L *lua.LState, name string, v lua.LValue) time.Duration {
+	switch v := v.(type) {
+	case lua.LNumber:
+		return time.Duration(float64(v) * float64(time.Second))
+	case lua.LString:
+		d, err := time.ParseDuration(string(v))
+		if err != nil {
+			L.RaiseError("invalid duration for %s: %v", name, err)
+		}
+		return d
+	default:
+		L.RaiseError("%s must be a string or a number, got %s", name, v.Type())
+		return 0
+	}
 }
 
-func (s *suite) newTest(runnerName string, _ *lua.LState) lua.LGFunction {
+func (s *suite) newTest(runnerName string, mode testMode) lua.LGFunction {
 	return func(L *lua.LState) int {
 		name := L.CheckString(1)
-		fn := L.CheckFunction(2)
+
+		opts := s.defaultTestOptions()
+		fnIdx := 2
+		if tbl, ok := L.Get(2).(*lua.LTable); ok {
+			opts = s.parseTestOptions(L, tbl)
+			fnIdx = 3
+		}
+		fn := L.CheckFunction(fnIdx)
+
+		reason := s.mgr.filter.skipReason(runnerName, name)
+		switch {
+		case mode == testModeSkip:
+			reason = "skipped"
+		case reason == "" && s.hasOnly && mode != testModeOnly:
+			reason = "not marked with only"
+		}
+
+		if reason != "" {
+			s.reporter.RunTest(L.Context(), runnerName, name, func(r reporter.Reporter) {
+				r.Skip(reason)
+			})
+			return 0
+		}
 
 		s.setup(L)
 
+		if err := s.runBeforeAll(L); err != nil {
+			s.reporter.RunTest(L.Context(), runnerName, name, func(r reporter.Reporter) {
+				r.Skip("BeforeAll hook failed")
+			})
+			return 0
+		}
+
 		var actualRunner spec.Runner
 		for _, r := range s.runners {
 			if r.Name() == runnerName {
@@ -181,31 +350,7 @@ func (s *suite) newTest(runnerName string, _ *lua.LState) lua.LGFunction {
 		ctxBeforeTest := L.Context()
 
 		s.reporter.RunTest(L.Context(), actualRunner.Name(), name, func(r reporter.Reporter) {
-			ctx := runner.WithSaveFunc(L.Context(), s.save)
-			ctx = runner.WithReporter(ctx, r)
-			L.SetContext(ctx)
-
-			mp := map[string]lua.LGFunction{}
-			for _, f := range actualRunner.Functions() {
-				mp[f.Name] = func(l *lua.LState) int {
-					return f.Func(l)
-				}
-			}
-
-			mod := L.SetFuncs(L.NewTable(), mp)
-
-			err := L.CallByParam(lua.P{
-				Fn:      fn,
-				Protect: true,
-			}, mod)
-			if err != nil {
-				// Check if this was a CheckError with structured data
-				if checkErr, ok := runner.GetCheckError(L.Context()); ok {
-					r.ReportError(reporter.NewDiffError(checkErr.Diff, checkErr.Expected, checkErr.Actual))
-				} else {
-					r.ReportError(reporter.NewError("%s", err.Error()))
-				}
-			}
+			s.runTest(L, r, actualRunner, fn, opts)
 		})
 
 		// Restore the file-level context so subsequent top-level code uses the file reporter
@@ -219,12 +364,115 @@ func (s *suite) newTest(runnerName string, _ *lua.LState) lua.LGFunction {
 	}
 }
 
+// runTest calls the test function with the runner functions as argument, and
+// reports any error raised by it. Failing tests are run again if the retry
+// option is set, and only the error of the last attempt is reported.
+func (s *suite) runTest(L *lua.LState, r reporter.Reporter, actualRunner spec.Runner, fn *lua.LFunction, opts testOptions) {
+	ctx := runner.WithSaveFunc(L.Context(), s.save)
+	ctx = runner.WithReporter(ctx, r)
+	defer runner.ReportCookies(ctx)
+
+	mp := map[string]lua.LGFunction{}
+	for _, f := range actualRunner.Functions() {
+		mp[f.Name] = func(l *lua.LState) int {
+			return f.Func(l)
+		}
+	}
+
+	mod := L.SetFuncs(L.NewTable(), mp)
+
+	for attempt := 0; ; attempt++ {
+		err := s.callTest(L, ctx, fn, mod, opts)
+		if err == nil {
+			if attempt > 0 {
+				runner.Info(ctx, reporter.Info{
+					Type:  reporter.InfoTypeRetry,
+					Title: fmt.Sprintf("Passed on attempt %d of %d", attempt+1, opts.retries+1),
+				})
+			}
+			return
+		}
+
+		if attempt >= opts.retries {
+			r.ReportError(err)
+			return
+		}
+
+		runner.Info(ctx, reporter.Info{
+			Type:    reporter.InfoTypeRetry,
+			Title:   fmt.Sprintf("Attempt %d of %d failed, retrying", attempt+1, opts.retries+1),
+			Content: err.Message,
+		})
+	}
+}
+
+// callTest makes a single call to the test function, and returns the error it
+// raised, if any.
+func (s *suite) callTest(L *lua.LState, ctx context.Context, fn *lua.LFunction, mod *lua.LTable, opts testOptions) *reporter.Error {
+	parent := ctx
+	if opts.timeout > 0 {
+		// gopher-lua aborts execution when the context is done
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	L.SetContext(ctx)
+
+	start := time.Now()
+	err := callHooks(L, "BeforeEach", s.hooks.beforeEach, mod)
+	if err == nil {
+		err = L.CallByParam(lua.P{
+			Fn:      fn,
+			Protect: true,
+		}, mod)
+	}
+	// AfterEach hooks are run even if the test failed, but the first error is
+	// reported. They run without the timeout of the test, so they can clean up
+	// after a test that timed out.
+	errCtx := L.Context()
+	L.SetContext(parent)
+	if afterErr := callHooks(L, "AfterEach", s.hooks.afterEach, mod); err == nil {
+		err = afterErr
+		errCtx = L.Context()
+	}
+	if err == nil {
+		return nil
+	}
+
+	if opts.timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return reporter.NewTimeoutError(opts.timeout, time.Since(start))
+	}
+
+	// Check if this was a CheckError with structured data
+	if checkErr, ok := runner.GetCheckError(errCtx); ok {
+		diffErr := reporter.NewDiffError(checkErr.Diff, checkErr.Expected, checkErr.Actual)
+		if hookErr, ok := err.(*hookError); ok {
+			diffErr.Message = hookErr.hook + " hook failed: " + diffErr.Message
+		}
+		return diffErr
+	}
+	return reporter.NewError("%s", err.Error())
+}
+
+// todo reports a test that is not implemented yet
+func (s *suite) todo(L *lua.LState) int {
+	name := L.CheckString(1)
+
+	s.reporter.RunTest(L.Context(), "", name, func(r reporter.Reporter) {
+		r.Todo()
+	})
+	return 0
+}
+
 func (s *suite) setup(L *lua.LState) {
 	if s.setupDone {
 		return
 	}
 
-	t := ConvertToGoType(L.GetGlobal("Config"))
+	t, err := spec.ToGoValue(L.GetGlobal("Config"))
+	if err != nil {
+		L.RaiseError("error decoding config: %v", err)
+	}
 
 	if err := mapstructure.Decode(t, s.cfg); err != nil {
 		L.RaiseError("error decoding config: %v", err)
@@ -233,7 +481,6 @@ func (s *suite) setup(L *lua.LState) {
 	// Preserve the reporter from the current context
 	currentReporter := runner.GetReporter(L.Context())
 
-	var err error
 	var ctx context.Context
 	ctx, s.runners, s.cleanup, err = s.mgr.doSetup(L.Context(), s.cfg)
 	if err != nil {
@@ -359,20 +606,7 @@ func formatLuaValue(v lua.LValue) string {
 	}
 }
 
+// save stores a value in State. Maps and lists are converted to tables.
 func (s *suite) save(key string, value any) {
-	var val lua.LValue
-	switch v := value.(type) {
-	case string:
-		val = lua.LString(v)
-	case int:
-		val = lua.LNumber(v)
-	case float64:
-		val = lua.LNumber(v)
-	case bool:
-		val = lua.LBool(v)
-	default:
-		panic(fmt.Sprintf("unsupported save type: %T", value))
-	}
-
-	s.state.RawSet(lua.LString(key), val)
+	s.state.RawSet(lua.LString(key), runner.ToLuaValue(value))
 }
@@ -0,0 +1,210 @@
+package lua
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nais/tester/lua/reporter"
+)
+
+// TAPReporter writes results in the Test Anything Protocol, version 14. Every
+// file is a subtest containing a test point for each test. The output of each
+// file is written when the file is done, and the final plan is written by Close.
+type TAPReporter struct {
+	w     io.Writer
+	lock  *sync.Mutex
+	state *tapState
+
+	file *tapFile
+	test *tapTest
+}
+
+type tapState struct {
+	headerWritten bool
+	count         int
+}
+
+type tapFile struct {
+	out    bytes.Buffer
+	count  int
+	failed bool
+}
+
+type tapTest struct {
+	errors    []*reporter.Error
+	directive string
+}
+
+func NewTAPReporter(w io.Writer) *TAPReporter {
+	return &TAPReporter{
+		w:     w,
+		lock:  &sync.Mutex{},
+		state: &tapState{},
+	}
+}
+
+func (r *TAPReporter) RunFile(ctx context.Context, filename string, fn func(reporter.Reporter)) {
+	file := &tapFile{}
+
+	fn(&TAPReporter{w: r.w, lock: r.lock, state: r.state, file: file})
+
+	fmt.Fprintf(&file.out, "    1..%d\n", file.count)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.writeHeader()
+	r.state.count++
+	fmt.Fprintf(r.w, "# Subtest: %s\n", tapEscape(filename))
+	_, _ = file.out.WriteTo(r.w)
+	fmt.Fprintf(r.w, "%s %d - %s\n", okString(!file.failed), r.state.count, tapEscape(filename))
+}
+
+func (r *TAPReporter) RunTest(ctx context.Context, runner, name string, fn func(reporter.Reporter)) {
+	start := time.Now()
+	test := &tapTest{}
+
+	fn(&TAPReporter{w: r.w, lock: r.lock, state: r.state, file: r.file, test: test})
+
+	// Todo tests are reported as not ok, which the TODO directive turns into a non-failure
+	ok := len(test.errors) == 0 && test.directive != "TODO"
+	if len(test.errors) > 0 {
+		r.file.failed = true
+	}
+
+	r.file.count++
+	fmt.Fprintf(&r.file.out, "    %s %d - %s", okString(ok), r.file.count, tapEscape(name))
+	if test.directive != "" {
+		fmt.Fprintf(&r.file.out, " # %s", test.directive)
+	}
+	r.file.out.WriteString("\n")
+
+	r.writeDiagnostics(test.errors, runner, time.Since(start))
+}
+
+// ReportError adds an error to the current test. Errors outside of a test are
+// reported as a failing test point named after the error.
+func (r *TAPReporter) ReportError(err *reporter.Error) {
+	switch {
+	case r.test != nil:
+		r.test.errors = append(r.test.errors, err)
+	case r.file != nil:
+		r.file.failed = true
+		r.file.count++
+		fmt.Fprintf(&r.file.out, "    not ok %d - %s\n", r.file.count, tapEscape(firstLine(err.Message)))
+		r.writeDiagnostics([]*reporter.Error{err}, "", 0)
+	}
+}
+
+// Info is written as TAP comments, which are ignored by TAP consumers.
+func (r *TAPReporter) Info(info reporter.Info) {
+	if r.file == nil {
+		return
+	}
+
+	indent := "    # "
+	if r.test != nil {
+		indent = "      # "
+	}
+
+	fmt.Fprintf(&r.file.out, "%s[%s] %s\n", indent, info.Type, info.Title)
+	for _, arg := range info.Args {
+		if arg.Name != "" {
+			fmt.Fprintf(&r.file.out, "%s  %s: %s\n", indent, arg.Name, arg.Value)
+		} else {
+			fmt.Fprintf(&r.file.out, "%s  %s\n", indent, arg.Value)
+		}
+	}
+	if info.Content != "" {
+		writeIndented(&r.file.out, info.Content, indent+"  ")
+	}
+}
+
+func (r *TAPReporter) Skip(reason string) {
+	r.test.directive = "SKIP " + reason
+}
+
+func (r *TAPReporter) Todo() {
+	r.test.directive = "TODO"
+}
+
+// Close writes the plan for all files run.
+func (r *TAPReporter) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.writeHeader()
+	_, err := fmt.Fprintf(r.w, "1..%d\n", r.state.count)
+	return err
+}
+
+func (r *TAPReporter) writeHeader() {
+	if r.state.headerWritten {
+		return
+	}
+	r.state.headerWritten = true
+	fmt.Fprintln(r.w, "TAP version 14")
+}
+
+// writeDiagnostics writes a YAML block describing the errors of a test point
+func (r *TAPReporter) writeDiagnostics(errs []*reporter.Error, runner string, duration time.Duration) {
+	if len(errs) == 0 {
+		return
+	}
+
+	out := &r.file.out
+	out.WriteString("      ---\n")
+	if len(errs) == 1 {
+		writeTAPError(out, errs[0], "      ", "      ")
+	} else {
+		out.WriteString("      errors:\n")
+		for _, err := range errs {
+			writeTAPError(out, err, "        - ", "          ")
+		}
+	}
+	if runner != "" {
+		fmt.Fprintf(out, "      runner: %s\n", yamlJSON(runner))
+	}
+	if duration > 0 {
+		fmt.Fprintf(out, "      duration_ms: %d\n", duration.Milliseconds())
+	}
+	out.WriteString("      ...\n")
+}
+
+func writeTAPError(w io.Writer, err *reporter.Error, first, indent string) {
+	fmt.Fprintf(w, "%smessage: |-\n", first)
+	writeIndented(w, err.Message, indent+"  ")
+	if err.Expected != nil || err.Actual != nil {
+		fmt.Fprintf(w, "%sexpected: %s\n", indent, yamlJSON(err.Expected))
+		fmt.Fprintf(w, "%sactual: %s\n", indent, yamlJSON(err.Actual))
+	}
+}
+
+// yamlJSON encodes v as JSON, which is valid YAML flow syntax
+func yamlJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%q", fmt.Sprint(v))
+	}
+	return string(b)
+}
+
+func okString(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "not ok"
+}
+
+// tapEscape escapes characters with special meaning in test point descriptions
+func tapEscape(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "#", "\\#")
+	return strings.ReplaceAll(s, "\n", " ")
+}
@@ -0,0 +1,112 @@
+package lua
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/nais/tester/lua/reporter"
+)
+
+func TestTAPReporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	r := NewTAPReporter(buf)
+	ctx := context.Background()
+
+	r.RunFile(ctx, "users.lua", func(r reporter.Reporter) {
+		r.RunTest(ctx, "gql", "list users", func(r reporter.Reporter) {
+			r.Info(reporter.Info{Type: reporter.InfoTypeQuery, Title: "GraphQL Query", Content: "{ users { id } }"})
+		})
+		r.RunTest(ctx, "gql", "get #1", func(r reporter.Reporter) {
+			r.ReportError(reporter.NewDiffError("-a\n+b", map[string]any{"a": 1}, map[string]any{"a": 2}))
+		})
+		r.RunTest(ctx, "sql", "skipped", func(r reporter.Reporter) {
+			r.Skip("not selected")
+		})
+		r.RunTest(ctx, "", "todo", func(r reporter.Reporter) {
+			r.Todo()
+		})
+	})
+	r.RunFile(ctx, "broken.lua", func(r reporter.Reporter) {
+		r.ReportError(reporter.NewError("syntax error"))
+	})
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := regexp.MustCompile(`duration_ms: \d+`).ReplaceAllString(buf.String(), "duration_ms: 0")
+
+	expected := `TAP version 14
+# Subtest: users.lua
+      # [query] GraphQL Query
+      #   { users { id } }
+    ok 1 - list users
+    not ok 2 - get \#1
+      ---
+      message: |-
+        diff -want +got:
+        -a
+        +b
+      expected: {"a":1}
+      actual: {"a":2}
+      runner: "gql"
+      duration_ms: 0
+      ...
+    ok 3 - skipped # SKIP not selected
+    not ok 4 - todo # TODO
+    1..4
+not ok 1 - users.lua
+# Subtest: broken.lua
+    not ok 1 - syntax error
+      ---
+      message: |-
+        syntax error
+      ...
+    1..1
+not ok 2 - broken.lua
+1..2
+`
+
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("TAPReporter mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	list := []any{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	changed := append(append([]any{}, list[:10]...), 12)
+
+	got := unifiedDiff(
+		map[string]any{"a": 1, "b": list},
+		map[string]any{"a": 2, "b": changed},
+	)
+
+	expected := `--- expected
++++ actual
+@@ -1,5 +1,5 @@
+ {
+-  "a": 1,
++  "a": 2,
+   "b": [
+     1,
+     2,
+@@ -11,6 +11,6 @@
+     8,
+     9,
+     10,
+-    11
++    12
+   ]
+ }
+`
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("unifiedDiff mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := unifiedDiff(list, list); got != "" {
+		t.Errorf("expected no diff for equal values, got:\n%s", got)
+	}
+}
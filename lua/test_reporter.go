@@ -8,6 +8,10 @@ import (
 	"github.com/nais/tester/lua/reporter"
 )
 
+// TestReporter reports files and tests as subtests of t. When the manager runs
+// files in parallel, t.Run is called from multiple goroutines, which the testing
+// package allows. t.Parallel is not used, as it would return control to the
+// manager before the file has finished.
 type TestReporter struct {
 	t    *testing.T
 	name string
@@ -36,3 +40,11 @@ func (r *TestReporter) ReportError(err *reporter.Error) {
 func (r *TestReporter) Info(info reporter.Info) {
 	r.t.Logf("[%s] %s: %s", info.Type, info.Title, fmt.Sprintf("%.100s", info.Content))
 }
+
+func (r *TestReporter) Skip(reason string) {
+	r.t.Skip(reason)
+}
+
+func (r *TestReporter) Todo() {
+	r.t.Skip("TODO")
+}